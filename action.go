@@ -0,0 +1,94 @@
+package gowatch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"mvdan.cc/sh/expand"
+	"mvdan.cc/sh/interp"
+	"mvdan.cc/sh/syntax"
+)
+
+// actionStopTimeout is how long the processes of a cancelled action have to
+// exit after being interrupted before they are killed. It matches the
+// default of the interpreter.
+const actionStopTimeout = 2 * time.Second
+
+type action struct {
+	// The directory to run the action in
+	Dir string
+
+	// The bash script to run
+	File *syntax.File
+
+	// How long the action may run for. Zero means no limit.
+	Timeout time.Duration
+
+	// A human-readable explanation of the action
+	Description string
+}
+
+// Run runs the action to completion. env holds extra environment variables
+// to pass to the action on top of the environment of the current process.
+//
+// Programs are interrupted when ctx is cancelled and killed if they haven't
+// exited after actionStopTimeout. If ctx tracks a processSet, as it does for
+// trigger sequences that may be signalled, each program runs in its own
+// process group instead, which is signalled as a whole.
+func (a *action) Run(ctx context.Context, env []string, stdout, stderr io.Writer) error {
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+
+	exec := interp.DefaultExec
+	if processSetFrom(ctx) != nil {
+		exec = groupExec(syscall.SIGINT, actionStopTimeout)
+	}
+
+	runner, err := interp.New(
+		interp.Dir(a.Dir),
+		interp.Env(expand.ListEnviron(mergeEnv(os.Environ(), env)...)),
+		interp.StdIO(nil, stdout, stderr),
+		interp.Module(exec),
+	)
+	if err != nil {
+		return err
+	}
+
+	err = runner.Run(ctx, a.File)
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", a.Timeout)
+	}
+	return err
+}
+
+// compileScript parses a script into a file that can be ran by the
+// interpreter. If the script uses an external shell, the file will invoke
+// the shell with the script instead.
+func compileScript(name string, s Script) (*syntax.File, error) {
+	if s.Shell == "" {
+		return syntax.NewParser().Parse(strings.NewReader(s.Run), name)
+	}
+
+	args := strings.Fields(s.Shell)
+	args = append(args, "-c", s.Run)
+
+	call := &syntax.CallExpr{}
+	for _, arg := range args {
+		call.Args = append(call.Args, &syntax.Word{
+			Parts: []syntax.WordPart{&syntax.SglQuoted{Value: arg}},
+		})
+	}
+
+	return &syntax.File{
+		Name:     name,
+		StmtList: syntax.StmtList{Stmts: []*syntax.Stmt{{Cmd: call}}},
+	}, nil
+}
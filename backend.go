@@ -0,0 +1,222 @@
+package gowatch
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// A Backend reports changes to the files in the directories it watches.
+// Events are reported for the watched directories and the files directly in
+// them, using the fsnotify event types.
+type Backend interface {
+	// Add starts watching a directory.
+	Add(dir string) error
+
+	// Remove stops watching a directory.
+	Remove(dir string) error
+
+	// Events returns the channel file events are sent to.
+	Events() <-chan fsnotify.Event
+
+	// Errors returns the channel errors are sent to.
+	Errors() <-chan error
+
+	// Close stops watching all directories.
+	Close() error
+}
+
+// BackendKind selects the Backend used to watch for file changes.
+type BackendKind string
+
+const (
+	// BackendAuto uses BackendNotify for every watched directory except
+	// those on filesystems known not to report file events, such as NFS,
+	// which use BackendPoll.
+	BackendAuto BackendKind = "auto"
+
+	// BackendNotify uses the file events of the operating system, such as
+	// inotify on Linux.
+	BackendNotify BackendKind = "notify"
+
+	// BackendPoll checks the watched directories for changes at an
+	// interval, comparing the modification time, size, and inode of each
+	// file. It works on every filesystem but is slower to notice changes.
+	BackendPoll BackendKind = "poll"
+
+	// BackendFanotify marks every watched filesystem as a whole with
+	// fanotify on Linux, rather than adding an inotify watch for each
+	// directory, and drops the events of directories that aren't watched.
+	// It avoids the inotify watch limit on large trees but needs
+	// CAP_SYS_ADMIN and Linux 5.9 or later; BackendNotify is used otherwise.
+	// Directories on filesystems that don't report file events are polled as
+	// with BackendAuto.
+	BackendFanotify BackendKind = "fanotify"
+)
+
+// defaultPollInterval is how often BackendPoll checks for changes by default.
+const defaultPollInterval = 500 * time.Millisecond
+
+func (k BackendKind) validate() error {
+	switch k {
+	case "", BackendAuto, BackendNotify, BackendPoll, BackendFanotify:
+		return nil
+	default:
+		return fmt.Errorf("unknown backend %q; expected auto, notify, poll, or fanotify", k)
+	}
+}
+
+// newBackend creates the Backend to watch for file changes with. fs picks
+// between native events and polling for each directory with BackendAuto and
+// BackendFanotify.
+func (w *Watcher) newBackend(fs *fsInspector) (Backend, error) {
+	if w.NewBackend != nil {
+		return w.NewBackend()
+	}
+
+	cfg := w.config()
+	interval := cfg.PollInterval
+	if interval == 0 {
+		interval = defaultPollInterval
+	}
+
+	if cfg.Backend == BackendPoll {
+		return newPollBackend(interval), nil
+	}
+
+	usePoll := func(dir string) bool {
+		s, _ := fs.strategy(dir)
+		return cfg.Backend != BackendNotify && s == strategyPoll
+	}
+
+	if cfg.Backend == BackendFanotify {
+		f, err := newFanotifyBackend(w.Directory)
+		if err == nil {
+			return newRoutedBackend(f, newPollBackend(interval), usePoll), nil
+		}
+		log.Printf("WARNING: fanotify unavailable (%v); falling back to inotify\n", err)
+	}
+
+	n, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Backend == BackendNotify && !cfg.PollOnWatchLimit {
+		return notifyBackend{n}, nil
+	}
+	return newRoutedBackend(notifyBackend{n}, newPollBackend(interval), usePoll), nil
+}
+
+// notifyBackend is a Backend using fsnotify.
+type notifyBackend struct {
+	n *fsnotify.Watcher
+}
+
+func (b notifyBackend) Add(dir string) error          { return b.n.Add(dir) }
+func (b notifyBackend) Remove(dir string) error       { return b.n.Remove(dir) }
+func (b notifyBackend) Events() <-chan fsnotify.Event { return b.n.Events }
+func (b notifyBackend) Errors() <-chan error          { return b.n.Errors }
+func (b notifyBackend) Close() error                  { return b.n.Close() }
+
+// A pollFallback is a Backend that can poll directories it fails to watch
+// otherwise.
+type pollFallback interface {
+	addPolled(dir string) error
+}
+
+// A routedBackend watches each directory with one of two backends and
+// merges their events.
+type routedBackend struct {
+	notify, poll Backend
+	usePoll      func(dir string) bool
+
+	events chan fsnotify.Event
+	errors chan error
+	done   chan struct{}
+	close  sync.Once
+
+	lock   sync.Mutex
+	polled map[string]bool
+}
+
+func newRoutedBackend(notify Backend, poll Backend, usePoll func(dir string) bool) *routedBackend {
+	b := &routedBackend{
+		notify:  notify,
+		poll:    poll,
+		usePoll: usePoll,
+		events:  make(chan fsnotify.Event),
+		errors:  make(chan error),
+		done:    make(chan struct{}),
+		polled:  make(map[string]bool),
+	}
+	go b.forward(notify)
+	go b.forward(poll)
+	return b
+}
+
+// forward sends the events and errors of src to the merged channels.
+func (b *routedBackend) forward(src Backend) {
+	for {
+		select {
+		case ev, ok := <-src.Events():
+			if !ok {
+				return
+			}
+			select {
+			case b.events <- ev:
+			case <-b.done:
+				return
+			}
+		case err, ok := <-src.Errors():
+			if !ok {
+				return
+			}
+			select {
+			case b.errors <- err:
+			case <-b.done:
+				return
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *routedBackend) Add(dir string) error {
+	if !b.usePoll(dir) {
+		return b.notify.Add(dir)
+	}
+	return b.addPolled(dir)
+}
+
+// addPolled polls dir for changes, regardless of its filesystem.
+func (b *routedBackend) addPolled(dir string) error {
+	b.lock.Lock()
+	b.polled[dir] = true
+	b.lock.Unlock()
+	return b.poll.Add(dir)
+}
+
+func (b *routedBackend) Remove(dir string) error {
+	b.lock.Lock()
+	polled := b.polled[dir]
+	delete(b.polled, dir)
+	b.lock.Unlock()
+
+	if polled {
+		return b.poll.Remove(dir)
+	}
+	return b.notify.Remove(dir)
+}
+
+func (b *routedBackend) Events() <-chan fsnotify.Event { return b.events }
+func (b *routedBackend) Errors() <-chan error          { return b.errors }
+
+func (b *routedBackend) Close() error {
+	b.close.Do(func() { close(b.done) })
+	b.poll.Close()
+	return b.notify.Close()
+}
@@ -0,0 +1,93 @@
+package gowatch
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fakeBackend is the Backend used by tests. It records the directories it
+// watches and reports the events sent by the test. Once it watches limit
+// directories, it fails like inotify does, with a wrapped error.
+type fakeBackend struct {
+	events chan fsnotify.Event
+	errors chan error
+	limit  int
+
+	lock   sync.Mutex
+	dirs   map[string]bool
+	polled []string
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		events: make(chan fsnotify.Event),
+		errors: make(chan error),
+		dirs:   make(map[string]bool),
+	}
+}
+
+func (b *fakeBackend) Add(dir string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.limit > 0 && len(b.dirs) >= b.limit {
+		return os.NewSyscallError("inotify_add_watch", syscall.ENOSPC)
+	}
+	b.dirs[dir] = true
+	return nil
+}
+
+func (b *fakeBackend) addPolled(dir string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.polled = append(b.polled, dir)
+	return nil
+}
+
+func (b *fakeBackend) Remove(dir string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delete(b.dirs, dir)
+	return nil
+}
+
+func (b *fakeBackend) Events() <-chan fsnotify.Event { return b.events }
+func (b *fakeBackend) Errors() <-chan error          { return b.errors }
+func (b *fakeBackend) Close() error                  { return nil }
+
+// watched returns the watched directories, sorted.
+func (b *fakeBackend) watched() []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var dirs []string
+	for d := range b.dirs {
+		dirs = append(dirs, d)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// write sends a write event for file, which is created or changed first.
+func (b *fakeBackend) write(t *testing.T, file string) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(file, []byte(time.Now().String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case b.events <- fsnotify.Event{Name: file, Op: fsnotify.Write}:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher is not reading events")
+	}
+}
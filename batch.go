@@ -0,0 +1,129 @@
+package gowatch
+
+import (
+	"sort"
+	"time"
+)
+
+const defaultDebounce = 250 * time.Millisecond
+
+// A fileChange is a change to a file that matched a file trigger.
+type fileChange struct {
+	// The order the change was seen in, which is used to keep steps in
+	// order when batches are combined.
+	seq int
+
+	// The index of the matching file trigger, its steps, and its policy
+	// for when the steps are still running
+	trigger int
+	steps   []Step
+	onBusy  BusyPolicy
+
+	file string
+	op   FileOp
+}
+
+// A batch collects the file changes matched by file triggers that share the
+// same batching settings.
+type batch struct {
+	Batching
+
+	changes []fileChange
+
+	// When the first and the latest change of the batch happened and when
+	// the batch was last flushed
+	first, last, flushed time.Time
+}
+
+func (b *batch) add(c fileChange, now time.Time) {
+	if len(b.changes) == 0 {
+		b.first = now
+	}
+	b.last = now
+	b.changes = append(b.changes, c)
+}
+
+// deadline returns when the batch should be flushed. ok is false if the
+// batch is empty.
+func (b *batch) deadline() (t time.Time, ok bool) {
+	if len(b.changes) == 0 {
+		return time.Time{}, false
+	}
+
+	switch {
+	case b.Settle > 0:
+		t = b.last.Add(b.Settle)
+		if b.MaxWait > 0 && b.first.Add(b.MaxWait).Before(t) {
+			t = b.first.Add(b.MaxWait)
+		}
+	case b.Debounce > 0:
+		t = b.first.Add(b.Debounce)
+	default:
+		t = b.first.Add(defaultDebounce)
+	}
+
+	if b.Throttle > 0 && !b.flushed.IsZero() && b.flushed.Add(b.Throttle).After(t) {
+		t = b.flushed.Add(b.Throttle)
+	}
+
+	return t, true
+}
+
+// batches groups file changes by their batching settings.
+type batches struct {
+	seq    int
+	groups map[Batching]*batch
+}
+
+func (bs *batches) add(settings Batching, c fileChange, now time.Time) {
+	if bs.groups == nil {
+		bs.groups = make(map[Batching]*batch)
+	}
+
+	b, ok := bs.groups[settings]
+	if !ok {
+		b = &batch{Batching: settings}
+		bs.groups[settings] = b
+	}
+
+	bs.seq++
+	c.seq = bs.seq
+	b.add(c, now)
+}
+
+// next returns when the earliest batch should be flushed. ok is false if
+// there are no pending changes.
+func (bs *batches) next() (next time.Time, ok bool) {
+	for _, b := range bs.groups {
+		t, pending := b.deadline()
+		if pending && (!ok || t.Before(next)) {
+			next, ok = t, true
+		}
+	}
+	return next, ok
+}
+
+// clear drops every pending change. When each batch was last flushed is
+// kept, so throttling still applies to later changes.
+func (bs *batches) clear() {
+	for _, b := range bs.groups {
+		b.changes = nil
+	}
+}
+
+// flush removes and returns the changes of every batch that is due at now,
+// in the order they were seen.
+func (bs *batches) flush(now time.Time) []fileChange {
+	var due []fileChange
+
+	for _, b := range bs.groups {
+		if t, pending := b.deadline(); pending && !t.After(now) {
+			due = append(due, b.changes...)
+			b.changes = nil
+			b.flushed = now
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool { return due[i].seq < due[j].seq })
+	return due
+}
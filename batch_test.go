@@ -0,0 +1,89 @@
+package gowatch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBatchDeadline(t *testing.T) {
+	start := time.Now()
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	tt := []struct {
+		name     string
+		settings Batching
+		flushed  time.Time
+		changes  []int
+		expect   time.Time
+	}{
+		{"default debounce", Batching{}, time.Time{}, []int{0, 100, 200}, at(250)},
+		{"debounce", Batching{Debounce: time.Second}, time.Time{}, []int{0, 900}, at(1000)},
+		{"settle", Batching{Settle: 500 * time.Millisecond}, time.Time{}, []int{0, 400, 800}, at(1300)},
+		{"settle with max wait", Batching{Settle: 500 * time.Millisecond, MaxWait: time.Second}, time.Time{}, []int{0, 400, 800}, at(1000)},
+		{"throttle", Batching{Throttle: time.Second}, at(0), []int{100}, at(1000)},
+		{"throttle passed", Batching{Throttle: time.Second}, at(-2000), []int{100}, at(350)},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &batch{Batching: tc.settings, flushed: tc.flushed}
+			for _, ms := range tc.changes {
+				b.add(fileChange{file: "main.go", op: OpWrite}, at(ms))
+			}
+
+			deadline, ok := b.deadline()
+			if !ok {
+				t.Fatal("expected batch to be pending")
+			}
+			if !deadline.Equal(tc.expect) {
+				t.Errorf("expected deadline %s, got %s", tc.expect.Sub(start), deadline.Sub(start))
+			}
+		})
+	}
+}
+
+func TestBatchesFlush(t *testing.T) {
+	var (
+		bs    batches
+		now   = time.Now()
+		fast  = Batching{Debounce: 100 * time.Millisecond}
+		slow  = Batching{Debounce: time.Second}
+		later = now.Add(200 * time.Millisecond)
+	)
+
+	bs.add(slow, fileChange{file: "a"}, now)
+	bs.add(fast, fileChange{file: "b"}, now)
+	bs.add(fast, fileChange{file: "c"}, now)
+
+	if next, _ := bs.next(); !next.Equal(now.Add(100 * time.Millisecond)) {
+		t.Errorf("expected next flush at 100ms, got %s", next.Sub(now))
+	}
+
+	due := bs.flush(later)
+	if len(due) != 2 || due[0].file != "b" || due[1].file != "c" {
+		t.Fatalf("expected changes b and c to be flushed, got %v", due)
+	}
+
+	if next, _ := bs.next(); !next.Equal(now.Add(time.Second)) {
+		t.Errorf("expected next flush at 1s, got %s", next.Sub(now))
+	}
+}
+
+func TestBusyPolicyFor(t *testing.T) {
+	tt := []struct {
+		policies []BusyPolicy
+		expect   BusyPolicy
+	}{
+		{nil, BusyRestart},
+		{[]BusyPolicy{""}, BusyRestart},
+		{[]BusyPolicy{BusyRestart, BusySignal}, BusySignal},
+		{[]BusyPolicy{BusySignal, BusyIgnore}, BusyIgnore},
+		{[]BusyPolicy{BusyIgnore, BusyQueue, BusySignal}, BusyQueue},
+	}
+
+	for _, tc := range tt {
+		if policy := busyPolicyFor(tc.policies); policy != tc.expect {
+			t.Errorf("expected policy for %v to be %s, got %s", tc.policies, tc.expect, policy)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package gowatch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bmatcuk/doublestar"
+)
+
+const (
+	// stateDir is where gowatch keeps its own files, relative to the
+	// watched directory. Changes within it are never watched.
+	stateDir = ".gowatch"
+
+	// cacheDir is where the hashes of the inputs of actions are stored,
+	// relative to the watched directory.
+	cacheDir = stateDir + "/cache"
+)
+
+// isStateFile returns whether file is within the state directory of the
+// tree at root. Writing the cache must not trigger anything, or every run
+// of an action with inputs would be followed by another one.
+func isStateFile(root string, file string) bool {
+	return isParent(filepath.Join(root, stateDir), file)
+}
+
+// inputsHash returns a hash of the script of an action, the variables set
+// by its env files and env settings, and the files matched by its inputs.
+func (w *Watcher) inputsHash(s Script) (string, error) {
+	h := sha256.New()
+
+	env, err := w.scriptEnv(w.config(), s)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "%q %q %q %q\n", s.Run, s.Shell, s.Dir, env)
+
+	var files []string
+	for _, pattern := range makeAbsolute(w.Directory, s.Inputs) {
+		matches, err := doublestar.Glob(pattern)
+		if err != nil {
+			return "", fmt.Errorf("invalid pattern %s: %v", pattern, err)
+		}
+
+		for _, m := range matches {
+			if !isDir(m) && !contains(files, m) {
+				files = append(files, m)
+			}
+		}
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		if err := hashFile(h, w.Directory, file); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(w io.Writer, root string, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(root, file)
+	if err != nil {
+		rel = file
+	}
+
+	fmt.Fprintf(w, "%q %d\n", rel, fi.Size())
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// cachePath returns the path of the file holding the cached hash of the
+// inputs of an action.
+func (w *Watcher) cachePath(name string) string {
+	return filepath.Join(w.Directory, cacheDir, url.PathEscape(name))
+}
+
+// cachedHash returns the hash of the inputs of an action from its last
+// successful run, or an empty string if there is none.
+func (w *Watcher) cachedHash(name string) string {
+	b, err := ioutil.ReadFile(w.cachePath(name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// saveCachedHash stores the hash of the inputs of a successful run of an
+// action.
+func (w *Watcher) saveCachedHash(name string, hash string) error {
+	p := w.cachePath(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a partially written hash is never
+	// read back.
+	tmp := p + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(hash+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
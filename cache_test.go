@@ -0,0 +1,156 @@
+package gowatch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActionInputsCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gowatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "main.go")
+	if err := ioutil.WriteFile(input, []byte("package main"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	log := filepath.Join(dir, "log")
+	w := NewWatcher(dir, Config{
+		Actions: map[string]Script{
+			"build": {Run: "echo build >> " + log, Inputs: []string{"*.go"}},
+		},
+	})
+
+	w.actions, err = w.compileActions(w.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runs := func() int {
+		if err := w.Run(context.Background(), "build"); err != nil {
+			t.Fatal(err)
+		}
+
+		b, _ := ioutil.ReadFile(log)
+		return len(strings.Fields(string(b)))
+	}
+
+	if n := runs(); n != 1 {
+		t.Fatalf("expected first run to run the action, ran %d times", n)
+	}
+	if n := runs(); n != 1 {
+		t.Fatalf("expected action to be skipped with unchanged inputs, ran %d times", n)
+	}
+
+	if err := ioutil.WriteFile(input, []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if n := runs(); n != 2 {
+		t.Fatalf("expected action to run after inputs changed, ran %d times", n)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, cacheDir, "build")); err != nil {
+		t.Errorf("expected cache to be persisted: %v", err)
+	}
+}
+
+func TestActionInputsCacheEnvFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gowatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	envFile := filepath.Join(dir, ".env")
+	if err := ioutil.WriteFile(envFile, []byte("MODE=debug\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewWatcher(dir, Config{
+		Actions: map[string]Script{
+			"build": {Run: "go build", EnvFile: ".env", Inputs: []string{"*.go"}},
+		},
+	})
+	script := w.Config.Actions["build"]
+
+	before, err := w.inputsHash(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Editing the env file changes the environment of the action, even
+	// though its path stays the same.
+	if err := ioutil.WriteFile(envFile, []byte("MODE=release\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	after, err := w.inputsHash(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == after {
+		t.Error("expected the hash to change with the contents of the env file")
+	}
+}
+
+func TestActionInputsCacheNotWatched(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "main.go")
+	if err := ioutil.WriteFile(input, []byte("package main"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewWatcher(dir, Config{
+		Actions: map[string]Script{
+			"build": {Run: "true", Inputs: []string{"*.go"}},
+		},
+		FileTriggers: []FileTrigger{{
+			Include:  []string{"./", "**/"},
+			Triggers: []Step{{Trigger: "build"}},
+			Batching: Batching{Debounce: 50 * time.Millisecond},
+		}},
+	})
+
+	events := w.Subscribe()
+	defer w.Unsubscribe(events)
+
+	done := make(chan error, 1)
+	go func() { done <- w.Start() }()
+	defer func() {
+		w.Close()
+		<-done
+	}()
+
+	// Give the watcher time to add its watches.
+	time.Sleep(200 * time.Millisecond)
+
+	if err := ioutil.WriteFile(input, []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Saving the cache after the run must not be seen as another change.
+	var batches [][]string
+	timeout := time.After(time.Second)
+	for loop := true; loop; {
+		select {
+		case ev := <-events:
+			if ev.Type == EventFileBatchDetected {
+				batches = append(batches, ev.Files)
+			}
+		case <-timeout:
+			loop = false
+		}
+	}
+	if len(batches) != 1 {
+		t.Fatalf("expected a single batch of changes, got %v", batches)
+	}
+}
@@ -0,0 +1,268 @@
+package gowatch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxInlineChanges is the largest number of changed files that will be
+// exported directly into a script's environment. Bigger batches are only
+// available through the file named by GOWATCH_CHANGED_LIST.
+const maxInlineChanges = 500
+
+// A changeSet holds the batch of changed files that caused a trigger
+// sequence to run, along with which steps of the sequence each file is
+// responsible for.
+type changeSet struct {
+	// The directory relative paths are computed against
+	root string
+
+	// The ordered list of steps to run. Every trigger appears in at most
+	// one step.
+	steps []Step
+
+	// Every changed file that caused a step to run
+	all []string
+
+	// The changed files that caused each trigger to run
+	files map[string][]string
+
+	// The indexes of the file triggers that caused each trigger to run
+	indexes map[string][]int
+
+	// The kinds of changes that caused each trigger to run
+	ops map[string][]FileOp
+
+	// Temporary files holding the list of changed files for each trigger,
+	// created on demand.
+	lists map[string]string
+
+	lock sync.Mutex
+}
+
+func newChangeSet(root string) *changeSet {
+	return &changeSet{
+		root:    root,
+		files:   make(map[string][]string),
+		indexes: make(map[string][]int),
+		ops:     make(map[string][]FileOp),
+		lists:   make(map[string]string),
+	}
+}
+
+// add records that a change of kind op to file caused the file trigger at
+// index idx to request step. Triggers of step that are already part of an
+// earlier step are not added again.
+func (c *changeSet) add(step Step, idx int, file string, op FileOp) {
+	if !contains(c.all, file) {
+		c.all = append(c.all, file)
+	}
+
+	var added []string
+	for _, trigger := range step.Names() {
+		if _, ok := c.files[trigger]; !ok {
+			added = append(added, trigger)
+		}
+		c.addTrigger(trigger, idx, file, op)
+	}
+
+	switch {
+	case len(added) == 0:
+	case step.Parallel != nil:
+		c.steps = append(c.steps, Step{Parallel: added})
+	default:
+		c.steps = append(c.steps, step)
+	}
+}
+
+func (c *changeSet) addTrigger(trigger string, idx int, file string, op FileOp) {
+	if !contains(c.files[trigger], file) {
+		c.files[trigger] = append(c.files[trigger], file)
+	}
+
+	c.addOp(trigger, op)
+
+	for _, i := range c.indexes[trigger] {
+		if i == idx {
+			return
+		}
+	}
+	c.indexes[trigger] = append(c.indexes[trigger], idx)
+	sort.Ints(c.indexes[trigger])
+}
+
+// triggers returns the names of all triggers in the change set in the order
+// they will run.
+func (c *changeSet) triggers() []string {
+	var names []string
+	for _, step := range c.steps {
+		names = append(names, step.Names()...)
+	}
+	return names
+}
+
+// addOp records op for trigger, keeping the ops of trigger in the order of
+// fileOps.
+func (c *changeSet) addOp(trigger string, op FileOp) {
+	if containsOp(c.ops[trigger], op) {
+		return
+	}
+
+	ops := []FileOp{}
+	for _, o := range fileOps {
+		if o == op || containsOp(c.ops[trigger], o) {
+			ops = append(ops, o)
+		}
+	}
+	c.ops[trigger] = ops
+}
+
+func containsOp(list []FileOp, op FileOp) bool {
+	for _, o := range list {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *changeSet) relative(files []string) []string {
+	rel := make([]string, 0, len(files))
+	for _, f := range files {
+		r, err := filepath.Rel(c.root, f)
+		if err != nil || strings.HasPrefix(r, "..") {
+			r = f
+		}
+		rel = append(rel, r)
+	}
+	return rel
+}
+
+// env returns the environment variables describing the changed files to
+// pass to trigger. The returned environment is empty when c is nil.
+func (c *changeSet) env(trigger string) ([]string, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	files := c.files[trigger]
+
+	idx := make([]string, 0, len(c.indexes[trigger]))
+	for _, i := range c.indexes[trigger] {
+		idx = append(idx, strconv.Itoa(i))
+	}
+
+	ops := make([]string, 0, len(c.ops[trigger]))
+	for _, op := range c.ops[trigger] {
+		ops = append(ops, string(op))
+	}
+
+	list, err := c.listFile(trigger)
+	if err != nil {
+		return nil, err
+	}
+
+	env := []string{
+		"GOWATCH_CHANGED_COUNT=" + strconv.Itoa(len(files)),
+		"GOWATCH_CHANGED_LIST=" + list,
+		"GOWATCH_FILE_TRIGGER=" + strings.Join(idx, " "),
+		"GOWATCH_EVENTS=" + strings.Join(ops, " "),
+	}
+
+	if len(files) <= maxInlineChanges {
+		env = append(env,
+			"GOWATCH_CHANGED_FILES="+strings.Join(files, "\n"),
+			"GOWATCH_CHANGED_FILES_REL="+strings.Join(c.relative(files), "\n"),
+		)
+	}
+
+	return env, nil
+}
+
+// listFile writes the absolute paths of the files that caused trigger to
+// run into a temporary file, one per line, and returns its path.
+func (c *changeSet) listFile(trigger string) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if p, ok := c.lists[trigger]; ok {
+		return p, nil
+	}
+
+	f, err := ioutil.TempFile("", "gowatch-changes-*.txt")
+	if err != nil {
+		return "", fmt.Errorf("failed to write changed files list: %v", err)
+	}
+	defer f.Close()
+
+	for _, file := range c.files[trigger] {
+		fmt.Fprintln(f, file)
+	}
+
+	c.lists[trigger] = f.Name()
+	return f.Name(), nil
+}
+
+// detach hands the list of changed files of trigger over to the caller, who
+// becomes responsible for removing it. Services keep running after the
+// trigger sequence that started them is done, so their list must outlive
+// the change set. It returns an empty path if no list was created.
+func (c *changeSet) detach(trigger string) string {
+	if c == nil {
+		return ""
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	p := c.lists[trigger]
+	delete(c.lists, trigger)
+	return p
+}
+
+// Close removes the temporary files created for the change set, except for
+// those handed over by detach.
+func (c *changeSet) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, p := range c.lists {
+		os.Remove(p)
+	}
+	c.lists = make(map[string]string)
+	return nil
+}
+
+// mergeEnv combines lists of KEY=VALUE pairs. Later lists take precedence
+// over earlier ones.
+func mergeEnv(lists ...[]string) []string {
+	keys := []string{}
+	values := make(map[string]string)
+
+	for _, list := range lists {
+		for _, kv := range list {
+			sep := strings.IndexByte(kv, '=')
+			if sep <= 0 {
+				continue
+			}
+
+			k := kv[:sep]
+			if _, ok := values[k]; !ok {
+				keys = append(keys, k)
+			}
+			values[k] = kv[sep+1:]
+		}
+	}
+
+	merged := make([]string, 0, len(keys))
+	for _, k := range keys {
+		merged = append(merged, k+"="+values[k])
+	}
+	return merged
+}
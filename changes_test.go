@@ -0,0 +1,162 @@
+package gowatch
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestChangeSetSteps(t *testing.T) {
+	c := newChangeSet("/src")
+	c.add(Step{Parallel: []string{"vet", "test"}}, 0, "/src/main.go", OpWrite)
+	c.add(Step{Trigger: "install"}, 0, "/src/main.go", OpWrite)
+	c.add(Step{Parallel: []string{"test", "lint"}}, 1, "/src/lint.toml", OpCreate)
+	c.add(Step{Trigger: "install"}, 1, "/src/lint.toml", OpCreate)
+
+	expect := []Step{
+		{Parallel: []string{"vet", "test"}},
+		{Trigger: "install"},
+		{Parallel: []string{"lint"}},
+	}
+	if !reflect.DeepEqual(c.steps, expect) {
+		t.Errorf("expected steps %+v, got %+v", expect, c.steps)
+	}
+
+	if files := c.files["test"]; !reflect.DeepEqual(files, []string{"/src/main.go", "/src/lint.toml"}) {
+		t.Errorf("unexpected files for test: %v", files)
+	}
+	if ops := c.ops["install"]; !reflect.DeepEqual(ops, []FileOp{OpCreate, OpWrite}) {
+		t.Errorf("unexpected ops for install: %v", ops)
+	}
+}
+
+func TestTriggerWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &triggerWriter{Name: "vet", w: &buf}
+
+	w.Write([]byte("one\ntw"))
+	w.Write([]byte("o\nthree\n"))
+
+	expect := "[vet] one\n[vet] two\n[vet] three\n"
+	if buf.String() != expect {
+		t.Errorf("expected %q, got %q", expect, buf.String())
+	}
+}
+
+// envMap turns a list of KEY=VALUE pairs into a map.
+func envMap(env []string) map[string]string {
+	m := make(map[string]string)
+	for _, kv := range env {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			m[kv[:i]] = kv[i+1:]
+		}
+	}
+	return m
+}
+
+func TestChangeSetEnv(t *testing.T) {
+	c := newChangeSet("/src")
+	defer c.Close()
+
+	c.add(Step{Trigger: "vet"}, 2, "/src/main.go", OpWrite)
+	c.add(Step{Trigger: "vet"}, 0, "/src/pkg/a.go", OpCreate)
+	c.add(Step{Trigger: "vet"}, 2, "/other/b.go", OpWrite)
+
+	env, err := c.env("vet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := envMap(env)
+
+	expect := map[string]string{
+		"GOWATCH_CHANGED_COUNT":     "3",
+		"GOWATCH_FILE_TRIGGER":      "0 2",
+		"GOWATCH_EVENTS":            "create write",
+		"GOWATCH_CHANGED_FILES":     "/src/main.go\n/src/pkg/a.go\n/other/b.go",
+		"GOWATCH_CHANGED_FILES_REL": "main.go\npkg/a.go\n/other/b.go",
+	}
+	for k, v := range expect {
+		if m[k] != v {
+			t.Errorf("expected %s=%q, got %q", k, v, m[k])
+		}
+	}
+
+	list, err := ioutil.ReadFile(m["GOWATCH_CHANGED_LIST"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(list) != "/src/main.go\n/src/pkg/a.go\n/other/b.go\n" {
+		t.Errorf("unexpected changed files list %q", list)
+	}
+
+	var nilSet *changeSet
+	if env, err := nilSet.env("vet"); env != nil || err != nil {
+		t.Errorf("expected no environment for a nil change set, got %v (%v)", env, err)
+	}
+}
+
+func TestChangeSetEnvInlineLimit(t *testing.T) {
+	for _, count := range []int{maxInlineChanges, maxInlineChanges + 1} {
+		c := newChangeSet("/src")
+		for i := 0; i < count; i++ {
+			c.add(Step{Trigger: "vet"}, 0, fmt.Sprintf("/src/%d.go", i), OpWrite)
+		}
+
+		env, err := c.env("vet")
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := envMap(env)
+		c.Close()
+
+		_, inline := m["GOWATCH_CHANGED_FILES"]
+		if inline != (count <= maxInlineChanges) {
+			t.Errorf("%d files: expected GOWATCH_CHANGED_FILES to be set: %v", count, count <= maxInlineChanges)
+		}
+		if m["GOWATCH_CHANGED_COUNT"] != strconv.Itoa(count) {
+			t.Errorf("%d files: unexpected GOWATCH_CHANGED_COUNT %s", count, m["GOWATCH_CHANGED_COUNT"])
+		}
+	}
+}
+
+func TestChangeSetDetach(t *testing.T) {
+	c := newChangeSet("/src")
+	c.add(Step{Parallel: []string{"server", "vet"}}, 0, "/src/main.go", OpWrite)
+
+	serverEnv, _ := c.env("server")
+	vetEnv, _ := c.env("vet")
+	server := envMap(serverEnv)["GOWATCH_CHANGED_LIST"]
+	vet := envMap(vetEnv)["GOWATCH_CHANGED_LIST"]
+	defer os.Remove(server)
+
+	if p := c.detach("server"); p != server {
+		t.Errorf("expected detach to return %s, got %s", server, p)
+	}
+	c.Close()
+
+	if _, err := os.Stat(server); err != nil {
+		t.Errorf("expected detached list to be kept: %v", err)
+	}
+	if _, err := os.Stat(vet); !os.IsNotExist(err) {
+		t.Errorf("expected list of vet to be removed, got %v", err)
+	}
+}
+
+func TestMergeEnvEdgeCases(t *testing.T) {
+	merged := mergeEnv(
+		[]string{"A=1", "B=2", "invalid", "=3"},
+		[]string{"C=x=y", "A=4"},
+		nil,
+		[]string{"B="},
+	)
+
+	expect := []string{"A=4", "B=", "C=x=y"}
+	if !reflect.DeepEqual(merged, expect) {
+		t.Errorf("expected %v, got %v", expect, merged)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rfratto/gowatch"
+	"github.com/spf13/cobra"
+)
+
+var ctlCmd = &cobra.Command{
+	Use:   "ctl",
+	Short: "control a running gowatch",
+	Long: `ctl sends commands to a running gowatch that was started with --control.
+The same address must be passed to ctl with --control.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// Errors from the control server aren't usage errors.
+		cmd.SilenceUsage = true
+	},
+}
+
+var ctlStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "list actions and services and their state",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var status gowatch.ControlStatus
+		if err := ctlRequest(http.MethodGet, "/status", &status); err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "paused:\t%v\n", status.Paused)
+
+		fmt.Fprintln(tw, "\nACTION\t\tDESCRIPTION")
+		for _, a := range status.Actions {
+			fmt.Fprintf(tw, "%s\t\t%s\n", a.Name, a.Description)
+		}
+
+		fmt.Fprintln(tw, "\nSERVICE\tSTATE\tDESCRIPTION")
+		for _, s := range status.Services {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Name, s.State, s.Description)
+		}
+
+		return tw.Flush()
+	},
+}
+
+var ctlRunCmd = &cobra.Command{
+	Use:   "run TRIGGER",
+	Short: "run an action or service and wait for it to finish",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		q := url.Values{"trigger": []string{args[0]}}
+		return ctlRequest(http.MethodPost, "/run?"+q.Encode(), nil)
+	},
+}
+
+func serviceVerbCmd(verb string, short string) *cobra.Command {
+	return &cobra.Command{
+		Use:   verb + " SERVICE",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := "/services/" + url.PathEscape(args[0]) + "/" + verb
+			return ctlRequest(http.MethodPost, path, nil)
+		},
+	}
+}
+
+var ctlPauseCmd = &cobra.Command{
+	Use:   "pause",
+	Short: "stop responding to file events",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return ctlRequest(http.MethodPost, "/pause", nil)
+	},
+}
+
+var ctlResumeCmd = &cobra.Command{
+	Use:   "resume",
+	Short: "resume responding to file events",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return ctlRequest(http.MethodPost, "/resume", nil)
+	},
+}
+
+var ctlEventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "stream events from gowatch",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		resp, err := ctlDo(http.MethodGet, "/events")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			var ev gowatch.Event
+			if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+				return err
+			}
+
+			fmt.Println(formatEvent(ev))
+		}
+		return scanner.Err()
+	},
+}
+
+// formatEvent returns a one-line human-readable description of ev.
+func formatEvent(ev gowatch.Event) string {
+	line := fmt.Sprintf("%s %s", ev.Time.Format("15:04:05.000"), ev.Type)
+	if ev.Trigger != "" {
+		line += " " + ev.Trigger
+	}
+
+	switch ev.Type {
+	case gowatch.EventFileBatchDetected:
+		line += fmt.Sprintf(" files=%d steps=%s", len(ev.Files), strings.Join(ev.Steps, ","))
+	case gowatch.EventServiceExited:
+		line += fmt.Sprintf(" code=%d", ev.ExitCode)
+	case gowatch.EventServiceRestarting:
+		line += fmt.Sprintf(" delay=%s", ev.Delay)
+	}
+
+	if ev.Error != "" {
+		line += ": " + ev.Error
+	}
+	return line
+}
+
+// ctlClient returns an HTTP client that connects to the control server.
+func ctlClient() (*http.Client, error) {
+	if controlAddress == "" {
+		return nil, fmt.Errorf("--control must be set")
+	}
+
+	network, address, err := gowatch.ParseControlAddress(controlAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, address)
+			},
+		},
+	}, nil
+}
+
+// ctlDo sends a request to the control server and returns the response if
+// it was successful.
+func ctlDo(method string, path string) (*http.Response, error) {
+	cli, err := ctlClient()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, "http://localhost"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(gowatch.ControlHeader, "1")
+
+	resp, err := cli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+
+		var cerr gowatch.ControlError
+		if err := json.NewDecoder(resp.Body).Decode(&cerr); err != nil || cerr.Error == "" {
+			return nil, fmt.Errorf("request failed: %s", resp.Status)
+		}
+		return nil, fmt.Errorf("%s", cerr.Error)
+	}
+
+	return resp, nil
+}
+
+// ctlRequest sends a request to the control server and decodes the response
+// into out if out is non-nil.
+func ctlRequest(method string, path string, out interface{}) error {
+	resp, err := ctlDo(method, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, err := io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func init() {
+	ctlCmd.PersistentFlags().StringVar(&controlAddress, "control", "", "address of the control server (unix:PATH or localhost:PORT)")
+
+	ctlCmd.AddCommand(
+		ctlStatusCmd,
+		ctlRunCmd,
+		serviceVerbCmd("start", "start a service if it is not running"),
+		serviceVerbCmd("stop", "stop a service"),
+		serviceVerbCmd("restart", "restart a service"),
+		ctlPauseCmd,
+		ctlResumeCmd,
+		ctlEventsCmd,
+	)
+
+	rootCmd.AddCommand(ctlCmd)
+}
@@ -3,8 +3,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/rfratto/gowatch"
 	"github.com/spf13/cobra"
@@ -16,6 +20,10 @@ var (
 	watchDirectory string
 	configFile     string
 	verbose        bool
+	controlAddress string
+	reloadConfig   bool
+	backend        string
+	pollInterval   time.Duration
 )
 
 var rootCmd = &cobra.Command{
@@ -54,31 +62,34 @@ directory except for vendor/, or if the Gopkg files change, then
 vet and test will be ran followed by restarting run if the previous two
 commands passed.
 
+When started with --reload, gowatch reloads its configuration file whenever it
+changes. Services whose definition did not change keep running.
+
+A running gowatch can be controlled with "gowatch ctl" when started with
+--control.
+
 Visit https://github.com/rfratto/gowatch for more information.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		r, err := os.Open(configFile)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to load configuration file: %v\n", err)
-			return
-		}
-		defer r.Close()
-
-		cfg := &gowatch.Config{}
-		err = yaml.NewDecoder(r).Decode(cfg)
+		cfg, err := loadConfig(configFile)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "decoding configuration failed: %v\n", err)
-			return
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
 		}
 
 		dir, err := os.Getwd()
 		if err != nil && watchDirectory == "" {
-			fmt.Fprintf(os.Stderr, "failed to get working directory: %v", err)
-			return
+			fmt.Fprintf(os.Stderr, "failed to get working directory: %v\n", err)
+			os.Exit(1)
 		} else if watchDirectory != "" {
 			dir = watchDirectory
 		}
 
-		w := gowatch.NewWatcher(dir, *cfg)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		sig := handleSignals(cancel)
+
+		w := gowatch.NewWatcherWithContext(ctx, dir, *cfg)
 		w.Stdout = os.Stdout
 		w.Stderr = os.Stderr
 
@@ -86,18 +97,80 @@ Visit https://github.com/rfratto/gowatch for more information.`,
 			w.Debug = os.Stderr
 		}
 
+		if controlAddress != "" {
+			l, err := gowatch.ListenControl(controlAddress)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to start control server: %v\n", err)
+				os.Exit(1)
+			}
+
+			go func() {
+				if err := w.ServeControl(l); err != nil && err != context.Canceled {
+					fmt.Fprintf(os.Stderr, "control server stopped: %v\n", err)
+				}
+			}()
+		}
+
+		if reloadConfig {
+			go watchConfig(ctx, w, configFile)
+		}
+
 		err = w.Start()
+
+		select {
+		case s := <-sig:
+			os.Exit(signalExitCode(s))
+		default:
+		}
+
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to start gowatch: %v", err)
-			return
+			fmt.Fprintf(os.Stderr, "failed to start gowatch: %v\n", err)
+			os.Exit(1)
 		}
 	},
 }
 
+// handleSignals calls shutdown when gowatch is asked to stop by SIGINT or
+// SIGTERM. The returned channel receives the signal once shutdown was
+// called. A second signal exits gowatch right away.
+func handleSignals(shutdown func()) <-chan os.Signal {
+	received := make(chan os.Signal, 1)
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		sig := <-sigs
+		fmt.Fprintf(os.Stderr, "received %s; stopping services\n", sig)
+		received <- sig
+		shutdown()
+
+		sig = <-sigs
+		fmt.Fprintf(os.Stderr, "received %s again; exiting immediately\n", sig)
+		os.Exit(signalExitCode(sig))
+	}()
+
+	return received
+}
+
+// signalExitCode returns the conventional exit status of a process that
+// was stopped by sig.
+func signalExitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
+
 func init() {
 	rootCmd.Flags().StringVarP(&configFile, "config", "c", "", "path to config file to load")
 	rootCmd.Flags().StringVarP(&watchDirectory, "dir", "d", "", "directory to watch. defaults to working directory")
 	rootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "adds extra output")
+	rootCmd.Flags().StringVar(&controlAddress, "control", "", "serve the control API at this address (unix:PATH or localhost:PORT)")
+
+	rootCmd.Flags().BoolVar(&reloadConfig, "reload", false, "reload the config file when it changes")
+	rootCmd.Flags().StringVar(&backend, "backend", "", "how to watch for file changes (auto, notify, poll, or fanotify). overrides the config file")
+	rootCmd.Flags().DurationVar(&pollInterval, "poll-interval", 0, "how often to check for changes with the poll backend. overrides the config file")
 
 	rootCmd.MarkFlagRequired("config")
 }
@@ -107,3 +180,27 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// loadConfig reads and decodes the configuration file at path.
+func loadConfig(path string) (*gowatch.Config, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration file: %v", err)
+	}
+	defer r.Close()
+
+	cfg := &gowatch.Config{}
+	err = yaml.NewDecoder(r).Decode(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("decoding configuration failed: %v", err)
+	}
+
+	if backend != "" {
+		cfg.Backend = gowatch.BackendKind(backend)
+	}
+	if pollInterval != 0 {
+		cfg.PollInterval = pollInterval
+	}
+
+	return cfg, nil
+}
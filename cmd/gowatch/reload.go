@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rfratto/gowatch"
+)
+
+// watchConfig reloads the config of w every time the file at path changes,
+// until ctx is cancelled. The directory of the file is watched rather than
+// the file itself, since many editors save files by replacing them.
+func watchConfig(ctx context.Context, w *gowatch.Watcher, path string) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to watch configuration file: %v\n", err)
+		return
+	}
+
+	n, err := fsnotify.NewWatcher()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to watch configuration file: %v\n", err)
+		return
+	}
+	defer n.Close()
+
+	if err := n.Add(filepath.Dir(abs)); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to watch configuration file: %v\n", err)
+		return
+	}
+
+	var flushTimer <-chan time.Time
+
+	for {
+		select {
+		case ev, ok := <-n.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(ev.Name) != abs || ev.Op == fsnotify.Chmod {
+				continue
+			}
+
+			// Wait for writes to settle before reading the file again.
+			flushTimer = time.After(250 * time.Millisecond)
+
+		case err, ok := <-n.Errors:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "error watching configuration file: %v\n", err)
+
+		case <-flushTimer:
+			flushTimer = nil
+
+			cfg, err := loadConfig(path)
+			if err == nil {
+				err = w.Reload(*cfg)
+			}
+
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "config reload failed: %v; keeping previous config\n", err)
+				continue
+			}
+			fmt.Fprintln(os.Stderr, "config reloaded")
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -1,13 +1,27 @@
 package gowatch
 
+import (
+	"fmt"
+	"time"
+)
+
 // Config holds the configuration for the directory tree that will be watched
 // and the scripts that will be ran on it.
 type Config struct {
 	// Actions is a named list of oneshot scripts.
-	Actions map[string]string `yaml:"actions"`
+	Actions map[string]Script `yaml:"actions"`
 
 	// Services is a named list of long-running scripts that are intended to not exit.
-	Services map[string]string `yaml:"services"`
+	Services map[string]Service `yaml:"services"`
+
+	// Env holds environment variables to set for every action and service.
+	Env map[string]string `yaml:"env"`
+
+	// EnvFile optionally holds the path to a .env file to load environment
+	// variables for every action and service from. Variables in Env take
+	// precedence over variables in EnvFile. Relative paths are relative to the
+	// working directory.
+	EnvFile string `yaml:"env_file"`
 
 	// StartupSteps holds the list of actions and services to run on start.
 	StartupSteps []string `yaml:"on_start"`
@@ -17,4 +31,251 @@ type Config struct {
 	// earlier triggers are treated as higher precedence and will execute
 	// first.
 	FileTriggers []FileTrigger `yaml:"file_triggers"`
+
+	// Batching controls how file changes are collected before the steps
+	// they trigger run. It can be overridden by each file trigger.
+	Batching Batching `yaml:",inline"`
+
+	// SkipUnchanged compares the content of changed files with the content
+	// they had when they were last seen, and ignores changes that left it
+	// the same, such as saving a file without editing it.
+	SkipUnchanged bool `yaml:"skip_unchanged"`
+
+	// UseIgnoreFiles makes file triggers skip files that are ignored by
+	// .git/info/exclude or by a .gitignore, .ignore, or .gowatchignore file
+	// anywhere in the watched directory. It can be overridden by each file
+	// trigger.
+	UseIgnoreFiles bool `yaml:"use_ignore_files"`
+
+	// Backend selects how file changes are watched for. Defaults to
+	// BackendAuto. Changes to it take effect when the watcher is started.
+	Backend BackendKind `yaml:"backend"`
+
+	// PollInterval is how often BackendPoll checks for changes. Defaults
+	// to 500ms.
+	PollInterval time.Duration `yaml:"poll_interval"`
+
+	// PollOnWatchLimit polls the directories that can't be watched once
+	// the inotify watch limit is reached, instead of failing to start.
+	// Changes to it take effect when the watcher is started.
+	PollOnWatchLimit bool `yaml:"poll_on_watch_limit"`
+}
+
+// Batching controls how file changes are collected into batches before the
+// steps they trigger are run.
+type Batching struct {
+	// Debounce is how long to collect changes for after the first change of
+	// a batch. Defaults to 250ms.
+	Debounce time.Duration `yaml:"debounce"`
+
+	// Settle makes a batch wait until no changes have happened for this long
+	// instead of using Debounce. This suits long bursts of changes, such as
+	// from switching branches.
+	Settle time.Duration `yaml:"settle"`
+
+	// MaxWait caps how long a batch waits to settle, counting from its first
+	// change. Zero means no limit.
+	MaxWait time.Duration `yaml:"max_wait"`
+
+	// Throttle is the minimum time between two runs caused by batches.
+	// Changes that happen sooner are held back until the interval passed.
+	// Zero means no limit.
+	Throttle time.Duration `yaml:"throttle"`
+}
+
+func (b Batching) validate() error {
+	if b.Debounce < 0 || b.Settle < 0 || b.MaxWait < 0 || b.Throttle < 0 {
+		return fmt.Errorf("durations must not be negative")
+	} else if b.MaxWait > 0 && b.MaxWait < b.Settle {
+		return fmt.Errorf("max_wait must not be less than settle")
+	}
+
+	return nil
+}
+
+// or returns b with unset fields taken from def.
+func (b Batching) or(def Batching) Batching {
+	if b.Debounce == 0 {
+		b.Debounce = def.Debounce
+	}
+	if b.Settle == 0 {
+		b.Settle = def.Settle
+	}
+	if b.MaxWait == 0 {
+		b.MaxWait = def.MaxWait
+	}
+	if b.Throttle == 0 {
+		b.Throttle = def.Throttle
+	}
+	return b
+}
+
+// Script is a script to run. Scripts can be defined in YAML either as a plain
+// string holding the script or as an object.
+type Script struct {
+	// Run holds the script to run.
+	Run string `yaml:"run"`
+
+	// Shell optionally holds an external shell to run the script with, such
+	// as bash or "zsh -e". The script is passed to the shell after a -c flag.
+	// When empty, the script is ran by gowatch's built-in POSIX shell
+	// interpreter.
+	Shell string `yaml:"shell"`
+
+	// Timeout limits how long an action may run before it is cancelled and
+	// considered failed. Zero means no limit. Services ignore Timeout; use a
+	// readiness probe to bound how long a service may take to start.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Description is a human-readable explanation of what the script does.
+	Description string `yaml:"description"`
+
+	// Dir is the directory to run the script in. Relative paths are relative
+	// to the working directory, which is also the default.
+	Dir string `yaml:"dir"`
+
+	// Env holds environment variables to set for the script. They take
+	// precedence over the global environment variables in the config.
+	Env map[string]string `yaml:"env"`
+
+	// EnvFile optionally holds the path to a .env file to load environment
+	// variables for the script from. Variables in Env take precedence over
+	// variables in EnvFile, and both take precedence over the global
+	// environment variables in the config. Relative paths are relative to the
+	// working directory.
+	EnvFile string `yaml:"env_file"`
+
+	// DependsOn holds the names of actions and services that must have run
+	// successfully before the script runs. Dependencies run at most once per
+	// trigger sequence, and dependencies that don't depend on each other run
+	// in parallel.
+	DependsOn []string `yaml:"depends_on"`
+
+	// Inputs holds patterns of the files an action reads. When set, the
+	// action is skipped if the matching files and the script are unchanged
+	// since its last successful run. Only supported for actions.
+	Inputs []string `yaml:"inputs"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler, allowing a script to be
+// defined as just the script to run.
+func (s *Script) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var run string
+	if err := unmarshal(&run); err == nil {
+		*s = Script{Run: run}
+		return nil
+	}
+
+	type plain Script
+	return unmarshal((*plain)(s))
+}
+
+func (s Script) validate() error {
+	if s.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+
+	return nil
+}
+
+// Service is a long-running script. Services can be defined in YAML either as
+// a plain string holding the script or as an object.
+type Service struct {
+	// Script holds the script to run and the settings shared with actions.
+	// Its fields are set at the same level as the service settings in YAML.
+	Script Script `yaml:",inline"`
+
+	// Ready optionally holds a probe that determines when the service has
+	// finished starting up. When set, triggering the service will wait for
+	// the probe to pass before the next step in the sequence runs.
+	Ready *ReadinessProbe `yaml:"ready"`
+
+	// Restart determines when the service is restarted after it exits.
+	// Defaults to RestartAlways.
+	Restart RestartPolicy `yaml:"restart"`
+
+	// MaxRestarts limits how many times in a row the service will be
+	// restarted after failing. Once the limit is hit, the service is given
+	// up on until it is triggered again. Zero means no limit.
+	MaxRestarts int `yaml:"max_restarts"`
+
+	// Backoff controls how long to wait before restarting the service.
+	Backoff Backoff `yaml:"backoff"`
+
+	// StopSignal is the signal sent to the processes of the service when
+	// it is stopped: SIGTERM, SIGINT, or SIGHUP. Defaults to SIGTERM.
+	StopSignal string `yaml:"stop_signal"`
+
+	// StopTimeout is how long the processes of the service have to exit
+	// after receiving StopSignal before they are killed. Defaults to 5s.
+	StopTimeout time.Duration `yaml:"stop_timeout"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler, allowing a service to be
+// defined as just its script.
+func (s *Service) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var run string
+	if err := unmarshal(&run); err == nil {
+		*s = Service{Script: Script{Run: run}}
+		return nil
+	}
+
+	type plain Service
+	return unmarshal((*plain)(s))
+}
+
+// RestartPolicy determines when a service is restarted after it exits.
+type RestartPolicy string
+
+const (
+	// RestartAlways restarts the service whenever it exits.
+	RestartAlways RestartPolicy = "always"
+
+	// RestartOnFailure restarts the service only when it exits with a
+	// non-zero status.
+	RestartOnFailure RestartPolicy = "on-failure"
+
+	// RestartNever never restarts the service.
+	RestartNever RestartPolicy = "never"
+)
+
+func (p RestartPolicy) validate() error {
+	switch p {
+	case "", RestartAlways, RestartOnFailure, RestartNever:
+		return nil
+	default:
+		return fmt.Errorf(
+			"unknown restart policy %s; must be one of %s, %s, or %s",
+			p, RestartAlways, RestartOnFailure, RestartNever,
+		)
+	}
+}
+
+// Backoff controls the delay between restarts of a service that keeps
+// exiting, whether it fails or not. The delay starts at Initial and doubles
+// after every consecutive early exit up to Max.
+type Backoff struct {
+	// Initial is the delay before the first restart. Defaults to 150ms.
+	Initial time.Duration `yaml:"initial"`
+
+	// Max caps the delay between restarts. Defaults to 30s. A service that
+	// ran for at least Max before exiting has its delay reset to Initial.
+	Max time.Duration `yaml:"max"`
+
+	// Jitter is a fraction between 0 and 1 of the delay to randomly add to
+	// it, which prevents services that fail together from restarting in
+	// lockstep.
+	Jitter float64 `yaml:"jitter"`
+}
+
+func (b Backoff) validate() error {
+	if b.Initial < 0 || b.Max < 0 {
+		return fmt.Errorf("backoff durations must not be negative")
+	} else if b.Jitter < 0 || b.Jitter > 1 {
+		return fmt.Errorf("backoff jitter must be between 0 and 1")
+	} else if b.Initial > 0 && b.Max > 0 && b.Initial > b.Max {
+		return fmt.Errorf("backoff initial must not be greater than max")
+	}
+
+	return nil
 }
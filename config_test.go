@@ -0,0 +1,111 @@
+package gowatch_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rfratto/gowatch"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestConfigScripts(t *testing.T) {
+	in := `
+actions:
+  vet: go vet ./...
+  test:
+    run: go test ./...
+    shell: bash -e
+    timeout: 5m
+    description: runs the tests
+services:
+  tick: echo tick
+  api:
+    run: go run ./cmd/api
+    description: runs the api
+    restart: on-failure
+    ready:
+      tcp: localhost:8080
+`
+
+	var cfg gowatch.Config
+	if err := yaml.Unmarshal([]byte(in), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	expectActions := map[string]gowatch.Script{
+		"vet": {Run: "go vet ./..."},
+		"test": {
+			Run:         "go test ./...",
+			Shell:       "bash -e",
+			Timeout:     5 * time.Minute,
+			Description: "runs the tests",
+		},
+	}
+	if !reflect.DeepEqual(cfg.Actions, expectActions) {
+		t.Errorf("expected actions %+v, got %+v", expectActions, cfg.Actions)
+	}
+
+	expectServices := map[string]gowatch.Service{
+		"tick": {Script: gowatch.Script{Run: "echo tick"}},
+		"api": {
+			Script: gowatch.Script{
+				Run:         "go run ./cmd/api",
+				Description: "runs the api",
+			},
+			Restart: gowatch.RestartOnFailure,
+			Ready:   &gowatch.ReadinessProbe{TCP: "localhost:8080"},
+		},
+	}
+	if !reflect.DeepEqual(cfg.Services, expectServices) {
+		t.Errorf("expected services %+v, got %+v", expectServices, cfg.Services)
+	}
+}
+
+func TestConfigBatching(t *testing.T) {
+	in := `
+debounce: 500ms
+file_triggers:
+  - include: ["*.go"]
+    settle: 1s
+    max_wait: 10s
+    trigger: [test]
+`
+
+	var cfg gowatch.Config
+	if err := yaml.Unmarshal([]byte(in), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := (gowatch.Batching{Debounce: 500 * time.Millisecond}); cfg.Batching != expect {
+		t.Errorf("expected batching %+v, got %+v", expect, cfg.Batching)
+	}
+
+	expect := gowatch.Batching{Settle: time.Second, MaxWait: 10 * time.Second}
+	if len(cfg.FileTriggers) != 1 || cfg.FileTriggers[0].Batching != expect {
+		t.Errorf("expected file trigger batching %+v, got %+v", expect, cfg.FileTriggers)
+	}
+}
+
+func TestConfigSteps(t *testing.T) {
+	in := `
+file_triggers:
+  - include: ["*.go"]
+    trigger:
+      - parallel: [vet, lint, test]
+      - install
+`
+
+	var cfg gowatch.Config
+	if err := yaml.Unmarshal([]byte(in), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []gowatch.Step{
+		{Parallel: []string{"vet", "lint", "test"}},
+		{Trigger: "install"},
+	}
+	if len(cfg.FileTriggers) != 1 || !reflect.DeepEqual(cfg.FileTriggers[0].Triggers, expect) {
+		t.Errorf("expected steps %+v, got %+v", expect, cfg.FileTriggers)
+	}
+}
@@ -0,0 +1,307 @@
+package gowatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ControlStatus is the response to a status request to the control server.
+type ControlStatus struct {
+	Paused   bool          `json:"paused"`
+	Actions  []ActionInfo  `json:"actions"`
+	Services []ServiceInfo `json:"services"`
+}
+
+// ControlHeader must be set, to any value, on every request to the control
+// server. Web pages can't set custom headers on cross-origin requests
+// without the consent of the server, so requiring it keeps any page opened
+// in a browser from using the control API.
+const ControlHeader = "X-Gowatch-Control"
+
+// ControlError is the body of a failed request to the control server.
+type ControlError struct {
+	Error string `json:"error"`
+}
+
+// ParseControlAddress splits the address of a control server into a network
+// and an address that can be passed to net.Listen or net.Dial. Addresses
+// starting with unix: are paths to a Unix socket. All other addresses are
+// TCP host:port pairs, which must refer to a loopback interface.
+func ParseControlAddress(addr string) (network string, address string, err error) {
+	if strings.HasPrefix(addr, "unix:") {
+		path := strings.TrimPrefix(strings.TrimPrefix(addr, "unix:"), "//")
+		if path == "" {
+			return "", "", fmt.Errorf("missing socket path in %s", addr)
+		}
+		return "unix", path, nil
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", err
+	}
+
+	if host != "localhost" {
+		ip := net.ParseIP(host)
+		if ip == nil || !ip.IsLoopback() {
+			return "", "", fmt.Errorf("control server must listen on localhost, not %s", host)
+		}
+	}
+
+	return "tcp", addr, nil
+}
+
+// ListenControl opens a listener for a control server at addr. See
+// ParseControlAddress for the format of addr. A stale Unix socket left
+// behind by a previous gowatch is removed.
+func ListenControl(addr string) (net.Listener, error) {
+	network, address, err := ParseControlAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if network == "unix" {
+		if _, err := os.Stat(address); err == nil {
+			conn, err := net.Dial(network, address)
+			if err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("%s is already in use", address)
+			}
+			os.Remove(address)
+		}
+	}
+
+	return net.Listen(network, address)
+}
+
+// ServeControl serves the control API on l until the watcher's context is
+// cancelled. The control API is a JSON HTTP API with the following
+// endpoints:
+//
+//	GET  /status                  paused state, actions, and services
+//	GET  /actions                 list of actions
+//	GET  /services                list of services and their state
+//	POST /run?trigger=NAME        run a trigger and wait for it to finish
+//	POST /services/NAME/start     start a service if it is not running
+//	POST /services/NAME/stop      stop a service
+//	POST /services/NAME/restart   restart a service
+//	POST /pause                   pause file watching
+//	POST /resume                  resume file watching
+//	GET  /events                  stream of newline-delimited JSON events
+//
+// The watcher must be started for triggers and services to be usable.
+// Triggers ran through the API run on the watcher's context: they keep
+// running when the client disconnects and are only cancelled when the
+// watcher stops.
+//
+// Requests must set ControlHeader and be addressed to localhost or a
+// loopback address; others are rejected with 403 Forbidden.
+func (w *Watcher) ServeControl(l net.Listener) error {
+	srv := &http.Server{Handler: w.controlHandler()}
+
+	ctx := w.context()
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			srv.Close()
+		case <-done:
+		}
+	}()
+
+	err := srv.Serve(l)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (w *Watcher) controlHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/status", func(rw http.ResponseWriter, r *http.Request) {
+		if !allowMethod(rw, r, http.MethodGet) {
+			return
+		}
+
+		writeJSON(rw, http.StatusOK, ControlStatus{
+			Paused:   w.Paused(),
+			Actions:  w.Actions(),
+			Services: w.Services(),
+		})
+	})
+
+	mux.HandleFunc("/actions", func(rw http.ResponseWriter, r *http.Request) {
+		if allowMethod(rw, r, http.MethodGet) {
+			writeJSON(rw, http.StatusOK, w.Actions())
+		}
+	})
+
+	mux.HandleFunc("/services", func(rw http.ResponseWriter, r *http.Request) {
+		if allowMethod(rw, r, http.MethodGet) {
+			writeJSON(rw, http.StatusOK, w.Services())
+		}
+	})
+
+	mux.HandleFunc("/services/", func(rw http.ResponseWriter, r *http.Request) {
+		if !allowMethod(rw, r, http.MethodPost) {
+			return
+		}
+
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/services/"), "/")
+		if len(parts) != 2 {
+			writeJSON(rw, http.StatusNotFound, ControlError{Error: "not found"})
+			return
+		}
+		name, verb := parts[0], parts[1]
+
+		if _, ok := w.service(name); !ok {
+			writeJSON(rw, http.StatusNotFound, ControlError{Error: "no service named " + name + " found"})
+			return
+		}
+
+		var err error
+		switch verb {
+		case "start":
+			err = w.StartService(w.context(), name)
+		case "stop":
+			err = w.StopService(name)
+		case "restart":
+			err = w.RestartService(w.context(), name)
+		default:
+			writeJSON(rw, http.StatusNotFound, ControlError{Error: "unknown verb " + verb})
+			return
+		}
+
+		writeResult(rw, err)
+	})
+
+	mux.HandleFunc("/run", func(rw http.ResponseWriter, r *http.Request) {
+		if !allowMethod(rw, r, http.MethodPost) {
+			return
+		}
+
+		trigger := r.URL.Query().Get("trigger")
+		if trigger == "" {
+			writeJSON(rw, http.StatusBadRequest, ControlError{Error: "missing trigger"})
+			return
+		}
+
+		writeResult(rw, w.runStep(w.context(), trigger, nil))
+	})
+
+	mux.HandleFunc("/pause", func(rw http.ResponseWriter, r *http.Request) {
+		if allowMethod(rw, r, http.MethodPost) {
+			w.Pause()
+			writeResult(rw, nil)
+		}
+	})
+
+	mux.HandleFunc("/resume", func(rw http.ResponseWriter, r *http.Request) {
+		if allowMethod(rw, r, http.MethodPost) {
+			w.Resume()
+			writeResult(rw, nil)
+		}
+	})
+
+	mux.HandleFunc("/events", func(rw http.ResponseWriter, r *http.Request) {
+		if !allowMethod(rw, r, http.MethodGet) {
+			return
+		}
+
+		flusher, ok := rw.(http.Flusher)
+		if !ok {
+			writeJSON(rw, http.StatusInternalServerError, ControlError{Error: "streaming not supported"})
+			return
+		}
+
+		ch := w.events.Subscribe()
+		defer w.events.Unsubscribe(ch)
+
+		rw.Header().Set("Content-Type", "application/x-ndjson")
+		rw.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		enc := json.NewEncoder(rw)
+		for {
+			select {
+			case ev := <-ch:
+				if err := enc.Encode(ev); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+
+	return guardControl(mux)
+}
+
+// guardControl rejects requests to h that may have been sent by a web page:
+// requests without ControlHeader, and requests addressed to a host other
+// than localhost, as sent by a page whose domain was made to resolve to a
+// loopback address.
+func guardControl(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get(ControlHeader) == "":
+			writeJSON(rw, http.StatusForbidden, ControlError{Error: "missing " + ControlHeader + " header"})
+		case !isLoopbackHost(r.Host):
+			writeJSON(rw, http.StatusForbidden, ControlError{Error: "host " + r.Host + " is not allowed"})
+		default:
+			h.ServeHTTP(rw, r)
+		}
+	})
+}
+
+// isLoopbackHost returns whether the host of a request, which may include a
+// port, is localhost or a loopback address.
+func isLoopbackHost(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func allowMethod(rw http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+
+	rw.Header().Set("Allow", method)
+	writeJSON(rw, http.StatusMethodNotAllowed, ControlError{Error: "method not allowed"})
+	return false
+}
+
+func writeResult(rw http.ResponseWriter, err error) {
+	switch {
+	case err == context.Canceled:
+		writeJSON(rw, http.StatusConflict, ControlError{Error: "cancelled"})
+	case err != nil:
+		writeJSON(rw, http.StatusInternalServerError, ControlError{Error: err.Error()})
+	default:
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(v)
+}
@@ -0,0 +1,273 @@
+package gowatch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// controlWatcher returns a watcher with compiled actions and services that
+// can be controlled without being started.
+func controlWatcher(t *testing.T) (*Watcher, string) {
+	t.Helper()
+
+	dir := tempDir(t)
+	w := NewWatcher(dir, Config{
+		Actions: map[string]Script{
+			"build": {Run: "sleep 0.1; echo build >> " + filepath.Join(dir, "log"), Description: "build it"},
+			"fail":  {Run: "exit 3"},
+		},
+		Services: map[string]Service{
+			"web": {Script: Script{Run: "sleep 30"}},
+		},
+	})
+
+	var err error
+	if w.actions, err = w.compileActions(w.Config); err != nil {
+		t.Fatal(err)
+	}
+	if w.services, err = w.compileServices(w.Config); err != nil {
+		t.Fatal(err)
+	}
+	return w, dir
+}
+
+// newControlRequest returns a request to the control handler, as sent by
+// gowatch ctl.
+func newControlRequest(method string, path string) *http.Request {
+	req := httptest.NewRequest(method, path, nil)
+	req.Host = "localhost"
+	req.Header.Set(ControlHeader, "1")
+	return req
+}
+
+// controlRequest sends a request to the control handler of w.
+func controlRequest(w *Watcher, method string, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	w.controlHandler().ServeHTTP(rec, newControlRequest(method, path))
+	return rec
+}
+
+func TestControlStatus(t *testing.T) {
+	w, dir := controlWatcher(t)
+	defer os.RemoveAll(dir)
+
+	rec := controlRequest(w, http.MethodGet, "/status")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+
+	var status ControlStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Paused || len(status.Actions) != 2 || status.Actions[0] != (ActionInfo{Name: "build", Description: "build it"}) {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if len(status.Services) != 1 || status.Services[0].Name != "web" || status.Services[0].State != ServiceStopped {
+		t.Errorf("unexpected services %+v", status.Services)
+	}
+
+	if rec := controlRequest(w, http.MethodPost, "/status"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected POST /status to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestControlRun(t *testing.T) {
+	w, dir := controlWatcher(t)
+	defer os.RemoveAll(dir)
+
+	tt := []struct {
+		path   string
+		status int
+	}{
+		{"/run?trigger=build", http.StatusNoContent},
+		{"/run?trigger=fail", http.StatusInternalServerError},
+		{"/run?trigger=missing", http.StatusInternalServerError},
+		{"/run", http.StatusBadRequest},
+	}
+	for _, tc := range tt {
+		if rec := controlRequest(w, http.MethodPost, tc.path); rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tc.path, tc.status, rec.Code, rec.Body)
+		}
+	}
+	if n := countLines(filepath.Join(dir, "log")); n != 1 {
+		t.Errorf("expected build to run once, ran %d times", n)
+	}
+
+	// A client going away doesn't cancel the trigger.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := httptest.NewRecorder()
+	req := newControlRequest(http.MethodPost, "/run?trigger=build").WithContext(ctx)
+	w.controlHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("expected run to finish after the client disconnected, got %d: %s", rec.Code, rec.Body)
+	}
+	if n := countLines(filepath.Join(dir, "log")); n != 2 {
+		t.Errorf("expected build to run twice, ran %d times", n)
+	}
+}
+
+func TestControlServices(t *testing.T) {
+	w, dir := controlWatcher(t)
+	defer os.RemoveAll(dir)
+	defer w.stopServices()
+
+	tt := []struct {
+		path   string
+		status int
+		state  ServiceState
+	}{
+		{"/services/web/start", http.StatusNoContent, ServiceRunning},
+		{"/services/web/start", http.StatusNoContent, ServiceRunning},
+		{"/services/web/stop", http.StatusNoContent, ServiceStopped},
+		{"/services/web/restart", http.StatusNoContent, ServiceRunning},
+		{"/services/web/reload", http.StatusNotFound, ServiceRunning},
+		{"/services/web", http.StatusNotFound, ServiceRunning},
+		{"/services/missing/start", http.StatusNotFound, ServiceRunning},
+		{"/services/missing/stop", http.StatusNotFound, ServiceRunning},
+		{"/services/missing/restart", http.StatusNotFound, ServiceRunning},
+	}
+
+	for _, tc := range tt {
+		if rec := controlRequest(w, http.MethodPost, tc.path); rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tc.path, tc.status, rec.Code, rec.Body)
+		}
+
+		// Services start in the background.
+		state, _ := w.ServiceState("web")
+		for start := time.Now(); state != tc.state && time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+			state, _ = w.ServiceState("web")
+		}
+		if state != tc.state {
+			t.Errorf("%s: expected web to be %s, got %s", tc.path, tc.state, state)
+		}
+	}
+}
+
+func TestControlPause(t *testing.T) {
+	w, dir := controlWatcher(t)
+	defer os.RemoveAll(dir)
+
+	if rec := controlRequest(w, http.MethodPost, "/pause"); rec.Code != http.StatusNoContent || !w.Paused() {
+		t.Errorf("expected pause to pause the watcher, got %d", rec.Code)
+	}
+	if rec := controlRequest(w, http.MethodPost, "/resume"); rec.Code != http.StatusNoContent || w.Paused() {
+		t.Errorf("expected resume to resume the watcher, got %d", rec.Code)
+	}
+	if rec := controlRequest(w, http.MethodGet, "/pause"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected GET /pause to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestControlEvents(t *testing.T) {
+	w, dir := controlWatcher(t)
+	defer os.RemoveAll(dir)
+
+	srv := httptest.NewServer(w.controlHandler())
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(ControlHeader, "1")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("unexpected content type %s", ct)
+	}
+
+	// The subscription exists once the headers were sent.
+	w.Pause()
+	w.Resume()
+
+	lines := bufio.NewScanner(resp.Body)
+	for _, expect := range []EventType{EventWatchPaused, EventWatchResumed} {
+		if !lines.Scan() {
+			t.Fatalf("event stream ended: %v", lines.Err())
+		}
+
+		var ev Event
+		if err := json.Unmarshal(lines.Bytes(), &ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type != expect {
+			t.Errorf("expected %s event, got %s", expect, ev.Type)
+		}
+	}
+}
+
+func TestControlGuard(t *testing.T) {
+	w, dir := controlWatcher(t)
+	defer os.RemoveAll(dir)
+
+	tt := []struct {
+		name   string
+		host   string
+		header bool
+		status int
+	}{
+		{"localhost", "localhost", true, http.StatusNoContent},
+		{"loopback with port", "127.0.0.1:7000", true, http.StatusNoContent},
+		{"IPv6 loopback", "[::1]:7000", true, http.StatusNoContent},
+		{"missing header", "localhost", false, http.StatusForbidden},
+		{"rebound domain", "attacker.example.com:7000", true, http.StatusForbidden},
+		{"other address", "192.168.1.10", true, http.StatusForbidden},
+	}
+
+	for _, tc := range tt {
+		req := newControlRequest(http.MethodPost, "/pause")
+		req.Host = tc.host
+		if !tc.header {
+			req.Header.Del(ControlHeader)
+		}
+
+		rec := httptest.NewRecorder()
+		w.controlHandler().ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tc.name, tc.status, rec.Code, rec.Body)
+		}
+		if rejected := rec.Code == http.StatusForbidden; rejected == w.Paused() {
+			t.Errorf("%s: expected the watcher to be paused only if the request was accepted", tc.name)
+		}
+		w.Resume()
+	}
+}
+
+func TestParseControlAddress(t *testing.T) {
+	tt := []struct {
+		addr             string
+		network, address string
+		ok               bool
+	}{
+		{"unix:/tmp/gowatch.sock", "unix", "/tmp/gowatch.sock", true},
+		{"unix:///tmp/gowatch.sock", "unix", "/tmp/gowatch.sock", true},
+		{"unix:", "", "", false},
+		{"localhost:7000", "tcp", "localhost:7000", true},
+		{"127.0.0.1:7000", "tcp", "127.0.0.1:7000", true},
+		{"[::1]:7000", "tcp", "[::1]:7000", true},
+		{"0.0.0.0:7000", "", "", false},
+		{"example.com:7000", "", "", false},
+		{"7000", "", "", false},
+	}
+
+	for _, tc := range tt {
+		network, address, err := ParseControlAddress(tc.addr)
+		if (err == nil) != tc.ok || network != tc.network || address != tc.address {
+			t.Errorf("%s: expected %s %s (ok %v), got %s %s (%v)", tc.addr, tc.network, tc.address, tc.ok, network, address, err)
+		}
+	}
+}
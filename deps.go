@@ -0,0 +1,155 @@
+package gowatch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// depRuns remembers the triggers that ran as part of a trigger sequence, so
+// that shared dependencies only run once.
+type depRuns struct {
+	lock sync.Mutex
+	runs map[string]*depRun
+}
+
+type depRun struct {
+	done chan struct{}
+	err  error
+}
+
+type depRunsKey struct{}
+
+// withDepRuns returns a context holding the depRuns of a trigger sequence,
+// creating them if ctx doesn't have any yet.
+func withDepRuns(ctx context.Context) (context.Context, *depRuns) {
+	if runs, ok := ctx.Value(depRunsKey{}).(*depRuns); ok {
+		return ctx, runs
+	}
+
+	runs := &depRuns{runs: make(map[string]*depRun)}
+	return context.WithValue(ctx, depRunsKey{}, runs), runs
+}
+
+// once calls fn the first time it is called for trigger. Later calls wait
+// for the first one to finish and return its error.
+func (d *depRuns) once(trigger string, fn func() error) error {
+	d.lock.Lock()
+	run, ok := d.runs[trigger]
+	if !ok {
+		run = &depRun{done: make(chan struct{})}
+		d.runs[trigger] = run
+	}
+	d.lock.Unlock()
+
+	if ok {
+		<-run.done
+		return run.err
+	}
+
+	run.err = fn()
+	close(run.done)
+	return run.err
+}
+
+// dependencies returns the names of the triggers that trigger depends on.
+func (w *Watcher) dependencies(trigger string) []string {
+	cfg := w.config()
+
+	if a, ok := cfg.Actions[trigger]; ok {
+		return a.DependsOn
+	} else if s, ok := cfg.Services[trigger]; ok {
+		return s.Script.DependsOn
+	}
+	return nil
+}
+
+// runDependencies runs the dependencies of trigger in parallel. Each
+// dependency runs its own dependencies first.
+func (w *Watcher) runDependencies(ctx context.Context, trigger string, changes *changeSet) error {
+	deps := w.dependencies(trigger)
+	if len(deps) == 0 {
+		return nil
+	}
+
+	err := w.runParallel(ctx, deps, changes)
+	if err != nil && err != context.Canceled {
+		return fmt.Errorf("dependency failed: %v", err)
+	}
+	return err
+}
+
+func (w *Watcher) validateDependencies() error {
+	deps := make(map[string][]string)
+	for name, a := range w.Config.Actions {
+		deps[name] = a.DependsOn
+	}
+	for name, s := range w.Config.Services {
+		deps[name] = s.Script.DependsOn
+	}
+
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, dep := range deps[name] {
+			if _, ok := deps[dep]; !ok {
+				return fmt.Errorf("%s depends on %s, but no action or service named %s exists", name, dep, dep)
+			}
+		}
+	}
+
+	// Look for cycles with a depth-first search, keeping the path to the
+	// current trigger to report the cycle.
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	var (
+		state = make(map[string]int)
+		path  []string
+		visit func(name string) error
+	)
+
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			for i, p := range path {
+				if p == name {
+					cycle := append(append([]string{}, path[i:]...), name)
+					return fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+				}
+			}
+		case visited:
+			return nil
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+
+		for _, dep := range deps[name] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package gowatch_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rfratto/gowatch"
+)
+
+func TestDependencyCycle(t *testing.T) {
+	w := gowatch.NewWatcher(wd(t), gowatch.Config{
+		Actions: map[string]gowatch.Script{
+			"generate": {Run: "true", DependsOn: []string{"build"}},
+			"build":    {Run: "true", DependsOn: []string{"generate"}},
+		},
+	})
+
+	err := w.Validate()
+	if err == nil || !strings.Contains(err.Error(), "dependency cycle") {
+		t.Fatalf("expected dependency cycle error, got %v", err)
+	}
+}
+
+func TestDependencyMissing(t *testing.T) {
+	w := gowatch.NewWatcher(wd(t), gowatch.Config{
+		Actions: map[string]gowatch.Script{
+			"build": {Run: "true", DependsOn: []string{"generate"}},
+		},
+	})
+
+	if err := w.Validate(); err == nil {
+		t.Fatal("expected error for missing dependency")
+	}
+}
+
+func TestRunDependencies(t *testing.T) {
+	p, err := ioutil.TempDir("", "gowatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(p)
+
+	log := filepath.Join(p, "log")
+	step := func(name string, deps ...string) gowatch.Script {
+		return gowatch.Script{Run: "echo " + name + " >> " + log, DependsOn: deps}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(p, "main.go"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := gowatch.NewWatcher(p, gowatch.Config{
+		Actions: map[string]gowatch.Script{
+			"generate": step("generate"),
+			"vet":      step("vet", "generate"),
+			"test":     step("test", "generate"),
+			"build":    step("build", "vet", "test"),
+		},
+		StartupSteps: []string{"build"},
+		FileTriggers: []gowatch.FileTrigger{
+			{Include: []string{"*.go"}, Triggers: []gowatch.Step{{Trigger: "build"}}},
+		},
+	})
+
+	go w.Start()
+	defer w.Close()
+
+	var b []byte
+	for start := time.Now(); !strings.Contains(string(b), "build"); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("build did not run; log: %q", b)
+		}
+		b, _ = ioutil.ReadFile(log)
+	}
+
+	lines := strings.Fields(string(b))
+	if len(lines) != 4 || lines[0] != "generate" || lines[3] != "build" {
+		t.Errorf("expected generate to run once first and build last, got %v", lines)
+	}
+}
+
+func TestRunDependenciesOncePerBatch(t *testing.T) {
+	tt := []struct {
+		name  string
+		deps  map[string][]string
+		steps []gowatch.Step
+	}{
+		{
+			name:  "sequence",
+			deps:  map[string][]string{"vet": {"generate"}, "test": {"generate"}},
+			steps: []gowatch.Step{{Trigger: "vet"}, {Trigger: "test"}},
+		},
+		{
+			name:  "parallel",
+			deps:  map[string][]string{"vet": {"generate"}, "test": {"generate"}},
+			steps: []gowatch.Step{{Parallel: []string{"vet", "test"}}},
+		},
+		{
+			name:  "parallel member as dependency",
+			deps:  map[string][]string{"test": {"vet"}},
+			steps: []gowatch.Step{{Parallel: []string{"vet", "test"}}},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := ioutil.TempDir("", "gowatch")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(p)
+			p, _ = filepath.EvalSymlinks(p)
+
+			log := filepath.Join(p, "log")
+			actions := make(map[string]gowatch.Script)
+			for _, name := range []string{"generate", "vet", "test", "done"} {
+				actions[name] = gowatch.Script{Run: "echo " + name + " >> " + log, DependsOn: tc.deps[name]}
+			}
+
+			w := gowatch.NewWatcher(p, gowatch.Config{
+				Actions: actions,
+				FileTriggers: []gowatch.FileTrigger{{
+					Include:  []string{"*.go"},
+					Triggers: append(tc.steps, gowatch.Step{Trigger: "done"}),
+					Batching: gowatch.Batching{Debounce: 20 * time.Millisecond},
+				}},
+			})
+
+			go w.Start()
+			defer w.Close()
+
+			// Give the watcher time to add its watches.
+			time.Sleep(200 * time.Millisecond)
+			if err := ioutil.WriteFile(filepath.Join(p, "main.go"), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			var b []byte
+			for start := time.Now(); !strings.Contains(string(b), "done"); time.Sleep(10 * time.Millisecond) {
+				if time.Since(start) > 5*time.Second {
+					t.Fatalf("done did not run; log: %q", b)
+				}
+				b, _ = ioutil.ReadFile(log)
+			}
+
+			runs := make(map[string]int)
+			for _, name := range strings.Fields(string(b)) {
+				runs[name]++
+			}
+			for name, n := range runs {
+				if n != 1 {
+					t.Errorf("expected %s to run once, ran %d times; log: %q", name, n, b)
+				}
+			}
+		})
+	}
+}
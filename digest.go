@@ -0,0 +1,128 @@
+package gowatch
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// digests tracks the content of watched files, so changes that don't alter
+// the content of a file can be skipped when Config.SkipUnchanged is set.
+type digests map[string]digest
+
+// A digest is what is known about the content of a file. Files are only
+// hashed once they change, so until then only their size and modification
+// time are known.
+type digest struct {
+	size    int64
+	modTime time.Time
+	hashed  bool
+	sum     [sha256.Size]byte
+}
+
+// seed records the size and modification time of the files directly within
+// dirs, the watched directories, so the first save of a file can already be
+// compared. Files aren't read: a file whose size and modification time are
+// the same on its first change is considered unchanged.
+func (d digests) seed(dirs []string) {
+	for _, dir := range dirs {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, fi := range infos {
+			file := filepath.Join(dir, fi.Name())
+			if _, ok := d[file]; !ok && fi.Mode().IsRegular() {
+				d[file] = digest{size: fi.Size(), modTime: fi.ModTime()}
+			}
+		}
+	}
+}
+
+// update hashes the content of file and reports whether it changed since
+// it was last seen. Files that can't be read are forgotten and always count
+// as changed.
+func (d digests) update(file string) (changed bool) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		delete(d, file)
+		return true
+	}
+
+	sum, err := digestFile(file)
+	if err != nil {
+		delete(d, file)
+		return true
+	}
+
+	prev, ok := d[file]
+	d[file] = digest{size: fi.Size(), modTime: fi.ModTime(), hashed: true, sum: sum}
+
+	switch {
+	case !ok:
+		return true
+	case !prev.hashed:
+		return prev.size != fi.Size() || !prev.modTime.Equal(fi.ModTime())
+	default:
+		return prev.sum != sum
+	}
+}
+
+// filter removes the changes of files whose content is the same as when
+// they were last seen. A file is compared by its state at the end of the
+// batch, so a file that was removed and written again with the same content,
+// as some editors do when saving, is skipped as well. Chmod events are kept,
+// since they never change content.
+func (d digests) filter(debug io.Writer, due []fileChange) []fileChange {
+	unchanged := make(map[string]bool)
+	for _, c := range due {
+		if _, seen := unchanged[c.file]; seen {
+			continue
+		}
+		unchanged[c.file] = !isDir(c.file) && !d.update(c.file)
+	}
+
+	var (
+		kept    []fileChange
+		skipped []string
+	)
+	for _, c := range due {
+		if c.op != OpChmod && unchanged[c.file] {
+			if !contains(skipped, c.file) {
+				skipped = append(skipped, c.file)
+			}
+			continue
+		}
+		kept = append(kept, c)
+	}
+
+	for _, file := range skipped {
+		fmt.Fprintf(debug, "skipping %s: content unchanged\n", file)
+	}
+	if len(kept) == 0 {
+		fmt.Fprintln(debug, "content of all changed files is unchanged; not running triggers")
+	}
+
+	return kept
+}
+
+func digestFile(file string) (sum [sha256.Size]byte, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
@@ -0,0 +1,131 @@
+package gowatch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDigestsFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gowatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := filepath.Join(dir, "a.go")
+	b := filepath.Join(dir, "b.go")
+	for _, file := range []string{a, b} {
+		if err := ioutil.WriteFile(file, []byte("package main"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := make(digests)
+	d.update(a)
+	d.update(b)
+
+	// Saving files without editing them skips the whole batch, but a chmod
+	// is kept.
+	kept := d.filter(ioutil.Discard, []fileChange{
+		{file: a, op: OpWrite},
+		{file: b, op: OpRemove},
+		{file: b, op: OpCreate},
+	})
+	if len(kept) != 0 {
+		t.Errorf("expected unchanged files to be skipped, got %+v", kept)
+	}
+
+	kept = d.filter(ioutil.Discard, []fileChange{{file: a, op: OpChmod}})
+	if len(kept) != 1 {
+		t.Errorf("expected chmod to be kept, got %+v", kept)
+	}
+
+	// Only the changes of the edited file are kept.
+	if err := ioutil.WriteFile(a, []byte("package other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	kept = d.filter(ioutil.Discard, []fileChange{
+		{file: a, op: OpWrite},
+		{file: b, op: OpWrite},
+	})
+	if len(kept) != 1 || kept[0].file != a {
+		t.Errorf("expected only %s to be kept, got %+v", a, kept)
+	}
+
+	// Removed files are always changed, and are changed again when they
+	// come back.
+	if err := os.Remove(b); err != nil {
+		t.Fatal(err)
+	}
+	kept = d.filter(ioutil.Discard, []fileChange{{file: b, op: OpRemove}})
+	if len(kept) != 1 {
+		t.Errorf("expected removal to be kept, got %+v", kept)
+	}
+
+	if err := ioutil.WriteFile(b, []byte("package main"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	kept = d.filter(ioutil.Discard, []fileChange{{file: b, op: OpCreate}})
+	if len(kept) != 1 {
+		t.Errorf("expected recreated file to be kept, got %+v", kept)
+	}
+}
+
+func TestDigestsSeed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gowatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	a := filepath.Join(dir, "a.go")
+	b := filepath.Join(sub, "b.go")
+	for _, file := range []string{a, b} {
+		if err := ioutil.WriteFile(file, []byte("package main"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := make(digests)
+	d.seed([]string{dir, sub})
+
+	if _, ok := d[sub]; ok {
+		t.Errorf("expected directories not to be recorded")
+	}
+	for _, file := range []string{a, b} {
+		if dg, ok := d[file]; !ok || dg.hashed {
+			t.Errorf("expected %s to be recorded without being hashed, got %+v", file, dg)
+		}
+	}
+
+	// A file saved without changes keeps its size and modification time.
+	if d.update(a) {
+		t.Errorf("expected untouched %s to be unchanged", a)
+	}
+	if !d[a].hashed {
+		t.Errorf("expected %s to be hashed after its first change", a)
+	}
+
+	// An edited file gets a new modification time.
+	later := time.Now().Add(time.Minute)
+	if err := ioutil.WriteFile(b, []byte("package sub"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(b, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if !d.update(b) {
+		t.Errorf("expected edited %s to be changed", b)
+	}
+	if d.update(b) {
+		t.Errorf("expected %s to be unchanged once hashed", b)
+	}
+}
@@ -34,6 +34,77 @@
 // gowatch.Watcher. Absolute paths can still be used to watch paths outside of the
 // working directory.
 //
+// gowatch watches every directory that could hold a file matched by a file
+// trigger, so files and directories created while it runs are picked up right
+// away without scanning the tree again. Directories that are removed or renamed
+// stop being watched under their old path, and are watched again when they are
+// recreated, such as by rm -rf build && mkdir build. Keeping patterns specific,
+// such as src/**/*.go rather than **/*.go, and excluding large directories
+// keeps the number of watched directories down.
+//
+// Patterns are applied in order, include patterns first, and the last pattern
+// that applies to a path decides whether it is watched. Excluding a directory
+// excludes everything below it. A pattern starting with ! does the opposite of
+// the list it is in, which allows including a subdirectory of an excluded
+// directory again:
+//
+//   file_triggers:
+//     - include: ["**/*.go", "!vendor/", "vendor/github.com/myorg/**/*.go"]
+//       trigger: [test]
+//
+// .git directories are never watched by patterns like **/*.go, since git
+// changes files in them all the time. A pattern pointing into one, like
+// .git/HEAD, still watches it.
+//
+// Instead of repeating .gitignore in exclude, use_ignore_files skips every file
+// ignored by .git/info/exclude or by a .gitignore, .ignore, or .gowatchignore
+// file at any level of the working directory. These files use gitignore syntax,
+// including negated (!), anchored (/), and directory-only (trailing /)
+// patterns. The option can be set at the top level of the config and
+// overridden by each file trigger:
+//
+//   use_ignore_files: true
+//   file_triggers:
+//     - include: ["**/*.go"]
+//       trigger: [test]
+//     - include: ["vendor/**/*.go"]
+//       use_ignore_files: false
+//       trigger: [vendor]
+//
+// Scripts
+//
+// Actions and services can be defined either as a plain string holding the
+// script or as an object with extra settings:
+//
+//   actions:
+//     vet: go vet ./...
+//     test:
+//       run: go test ./...
+//       description: runs the unit tests
+//       shell: bash -e
+//       timeout: 5m
+//
+// Scripts are ran by a built-in POSIX shell interpreter unless shell is set,
+// in which case the script is passed to that shell after a -c flag. An action
+// that runs for longer than its timeout is cancelled and considered failed.
+//
+// Scripts run in the working directory unless dir is set. Environment
+// variables can be set for every script with env and env_file at the top level
+// of the config, and for a single script with its own env and env_file:
+//
+//   env:
+//     GOFLAGS: -mod=vendor
+//   env_file: .env
+//   actions:
+//     frontend:
+//       run: npm run build
+//       dir: frontend
+//       env:
+//         NODE_ENV: production
+//
+// Variables from env take precedence over variables from env_file, and
+// variables set on a script take precedence over global ones.
+//
 // Triggers
 //
 // When a sequence of scripts is triggered, actions will be fired off
@@ -42,13 +113,158 @@
 // It will be started if it is not currently running, and it will be restarted
 // if it is.
 //
+// Service Readiness
+//
+// Starting a service normally returns immediately, so the next script in the
+// sequence may run before the service is able to handle requests. A service
+// may define a readiness probe to make gowatch wait until it has started up:
+//
+//   services:
+//     api:
+//       run: go run ./cmd/api
+//       ready:
+//         http: http://localhost:8080/healthz
+//         timeout: 1m
+//
+// A probe can check that a TCP address accepts connections (tcp), that an
+// HTTP GET returns a 2xx status (http), that the service logs a line matching
+// a regular expression (log), or that a script exits successfully (script).
+// If the probe does not pass within its timeout (30s by default), or the
+// service exits before it passes, the trigger fails and the rest of the
+// sequence is aborted.
+//
+// Service Restarts
+//
+// By default, services are restarted whenever they exit. The restart option
+// of a service changes this to only restart after a non-zero exit status
+// (on-failure) or to never restart (never). A service that keeps exiting,
+// whether it fails or not, is restarted with an exponential backoff, starting
+// at 150ms and doubling up to 30s; both bounds and a random jitter can be
+// configured through backoff. The backoff is reset once a service runs for
+// as long as the maximum delay. After exiting early 5 times in a row, a
+// service is reported as being in a crash loop. If max_restarts is set,
+// gowatch gives up on a service after it failed that many times in a row,
+// until the service is triggered again:
+//
+//   services:
+//     api:
+//       run: go run ./cmd/api
+//       restart: on-failure
+//       max_restarts: 10
+//       backoff:
+//         initial: 500ms
+//         max: 1m
+//         jitter: 0.2
+//
+// Stopping Services
+//
+// Every program started by a service runs in its own process group. When a
+// service is stopped or restarted, its stop_signal (SIGTERM, SIGINT, or SIGHUP;
+// SIGTERM by default) is sent to the whole group, so processes started by the
+// program, such as the server started by go run, are stopped too. Processes
+// that are still running after stop_timeout (5s by default) are killed. A
+// restarted service is only started again once its previous processes have
+// exited. Programs of services are not left running in the background:
+// processes left in the group of a program when it exits are killed.
+//
+// Changed Files
+//
+// Scripts ran in response to file events can find out which files caused them
+// to run through the following environment variables:
+//
+//   GOWATCH_CHANGED_COUNT      number of changed files
+//   GOWATCH_CHANGED_FILES      newline-separated absolute paths of changed files
+//   GOWATCH_CHANGED_FILES_REL  the same paths, relative to the working directory
+//   GOWATCH_CHANGED_LIST       path to a temporary file listing the absolute paths
+//   GOWATCH_FILE_TRIGGER       space-separated indexes of the matching file_triggers
+//   GOWATCH_EVENTS             space-separated kinds of changes, such as "create write"
+//
+// Only files matched by a file trigger that requested the script are included.
+// For very large batches, GOWATCH_CHANGED_FILES and GOWATCH_CHANGED_FILES_REL
+// are left unset and GOWATCH_CHANGED_LIST must be used instead. The list file
+// of an action is removed once the trigger sequence finishes; the list file of
+// a service is kept until the service stops, so it is still there when the
+// service restarts. None of these variables are set for scripts ran on
+// startup.
+//
 // File System Events
 //
 // File Triggers are collected in batches in case of many files changing at once.
-// Whenever a file change is detected, a 250ms timer starts. All other file changes
-// within that 250ms window will be collected. After that 250ms timer expires, all
-// file updates collected in that batch will be analyzed and the proper triggers
-// will fire.
+// Whenever a file change is detected, a timer starts. All other file changes
+// within that window will be collected. After the timer expires, all file
+// updates collected in that batch will be analyzed and the proper triggers
+// will fire. The window is 250ms by default and can be changed with debounce.
+//
+// Setting settle instead makes a batch wait until no files changed for that
+// long, which suits long bursts of changes like switching branches; max_wait
+// caps how long a batch may wait to settle. throttle makes sure runs caused
+// by batches are at least that far apart. These options can be set at the top
+// level of the config and overridden by each file trigger:
+//
+//   debounce: 100ms
+//   file_triggers:
+//     - include: ["**/*.go"]
+//       settle: 500ms
+//       max_wait: 5s
+//       trigger: [test]
+//     - include: ["docs/**/*.md"]
+//       throttle: 30s
+//       trigger: [docs]
+//
+// Some tools write files without changing them, like formatters or editors
+// saving twice. With skip_unchanged, gowatch keeps a hash of the content of
+// each changed file and drops changes to files whose content is the same as
+// before once a batch is collected. If no changed file has new content, no
+// triggers run. The skipped files are listed in the debug output. Files are
+// only read once they change: on its first change, a file is compared with
+// the size and modification time it had when gowatch started.
+//
+//   skip_unchanged: true
+//
+// By default, file triggers respond to files being created, written, removed,
+// or renamed. The events option of a file trigger limits it to some of these
+// kinds of changes, or adds chmod to also respond to permission changes:
+//
+//   file_triggers:
+//     - include: ["migrations/*.sql"]
+//       events: [create]
+//       trigger: [migrate]
+//
+// Watch Backends
+//
+// File changes are reported by the operating system through fsnotify. Some
+// filesystems, like NFS, SMB, 9p, virtiofs, VirtualBox shared folders, and some
+// FUSE mounts, don't report changes made from another machine or from the host
+// of a VM or container. On Linux, gowatch looks up the filesystem of each
+// watched directory in /proc/self/mountinfo and polls these directories for
+// changes instead, comparing the modification time, size, and inode of their
+// files. Directories on the osxfs and grpcfuse mounts of Docker Desktop use
+// native events, but their parent directories up to the mount point are
+// watched as well, without which no events are reported. With --verbose, the
+// strategy picked for each mount is printed. The backend option overrides the
+// choice for every directory:
+//
+//   backend: poll        # auto (the default), notify, poll, or fanotify
+//   poll_interval: 1s    # defaults to 500ms
+//
+// The gowatch command also accepts --backend and --poll-interval. Programs
+// embedding a Watcher can provide their own Backend through NewBackend.
+//
+// On Linux, every watched directory uses one of the inotify watches allowed
+// by fs.inotify.max_user_watches. When the limit is reached, gowatch fails to
+// start and reports how many directories it needed and what the limit is.
+// With poll_on_watch_limit, it polls the directories it couldn't watch
+// instead:
+//
+//   poll_on_watch_limit: true
+//
+// The fanotify backend avoids the limit on Linux 5.9 and later by watching
+// each filesystem holding a watched directory as a whole, and dropping the
+// events of every other directory before they are matched against the file
+// triggers. It needs CAP_SYS_ADMIN, usually by running as root; without it,
+// gowatch prints a warning and uses inotify:
+//
+//   backend: fanotify
 //
 // Trigger Priority
 //
@@ -56,10 +272,95 @@
 // match, the actions and services will be ran in definition order with duplicates
 // removed. Each action will be run to completion before the next one is started.
 //
+// Parallel Triggers
+//
+// A step of a trigger list can run a group of triggers at the same time:
+//
+//   trigger:
+//     - parallel: [vet, lint, test]
+//     - install
+//
+// The next step starts once all triggers of the group have finished. If one of
+// them fails, the others are cancelled and the rest of the sequence is skipped.
+// Each line of output is prefixed with the name of the trigger that wrote it.
+//
+// Dependencies
+//
+// Actions and services can list other actions and services they depend on
+// with depends_on. Dependencies run before the trigger that depends on them,
+// and dependencies that don't depend on each other run in parallel. Within
+// one trigger sequence, every trigger runs at most once, so dependencies
+// shared by several steps are only ran for the first of them. Dependency
+// cycles are reported as configuration errors.
+//
+//   actions:
+//     generate: go generate ./...
+//     build:
+//       run: go build ./...
+//       depends_on: [generate]
+//
+// Input Caching
+//
+// An action can list the files it reads with inputs. Before running the action,
+// gowatch hashes its script, its environment including the contents of env
+// files, and the files matching inputs, and skips the action if the hash is
+// the same as on its last successful run. Hashes are stored in
+// .gowatch/cache in the watched directory, so they are kept across restarts.
+// Changes within .gowatch are never watched, whatever the file triggers
+// include.
+//
+//   actions:
+//     test:
+//       run: go test ./...
+//       inputs: ["**/*.go", "go.mod", "go.sum"]
+//
 // Trigger Cancellation
 //
 // If another trigger event occurs while one or more triggers is queued up to run,
 // then the queue will be cancelled and the running trigger will be aborted.
+// This can be changed for each file trigger through on_busy:
+//
+//   restart  cancel the running triggers and start over (the default)
+//   queue    let the running triggers finish, then run once more for all
+//            changes that happened in the meantime
+//   ignore   let the running triggers finish and drop the new changes
+//   signal   send SIGHUP to the running trigger and drop the new changes
+//
+// With signal, the changes that arrived while the trigger was running are
+// thrown away: the running trigger is expected to pick them up when it
+// reloads, and it is not ran again for them. The programs of such triggers
+// run in their own process group, like those of services, so the signal
+// reaches the processes they started too. With the other policies, aborted
+// programs are interrupted and killed after 2s if they haven't exited.
+//
+// When the running triggers were caused by several file triggers with
+// different policies, queue takes precedence over ignore, which takes
+// precedence over signal, which takes precedence over restart.
+//
+// Events
+//
+// Programs embedding a Watcher can follow what it is doing through
+// Watcher.Subscribe, which returns a channel of typed events such as
+// EventFileBatchDetected, EventTriggerFailed, or EventServiceExited. The same
+// events are streamed by the control API.
+//
+// Shutting Down
+//
+// gowatch stops all services gracefully and exits when it receives SIGINT or
+// SIGTERM, exiting with 128 plus the number of the signal. A second signal
+// makes gowatch exit right away. Programs embedding a Watcher can do the same
+// by cancelling the context passed to NewWatcherWithContext or by calling
+// Watcher.Shutdown or Watcher.Close, which wait for services to stop.
+//
+// Reloading
+//
+// Watcher.Reload replaces the config of a running watcher, and gowatch calls it
+// whenever its config file changes when started with --reload. Invalid configs
+// are rejected and the previous config is kept. Removed services are stopped,
+// running services whose definition or environment changed are restarted, and
+// all other services keep running. The environment includes the contents of
+// env files, so editing a .env file and reloading restarts the services using
+// it. Startup steps are not run again.
 //
 // Here is an example configuration YAML file for a NodeJS project that uses gulp:
 //
@@ -0,0 +1,134 @@
+package gowatch
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// loadEnvFile reads a .env file and returns its variables as KEY=VALUE
+// pairs. Relative paths are resolved against root.
+func loadEnvFile(root string, path string) ([]string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env, err := parseEnvFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return env, nil
+}
+
+// parseEnvFile parses the contents of a .env file. Each line holds a
+// KEY=VALUE pair, optionally prefixed with export. Blank lines and lines
+// starting with # are ignored. Values may be wrapped in single quotes, which
+// are taken literally, or double quotes, which support \n, \t, \" and \\
+// escapes. Unquoted values end at the first " #".
+func parseEnvFile(r io.Reader) ([]string, error) {
+	env := []string{}
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		sep := strings.IndexByte(line, '=')
+		if sep <= 0 {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNum)
+		}
+
+		key := strings.TrimSpace(line[:sep])
+		if !validEnvName(key) {
+			return nil, fmt.Errorf("line %d: invalid variable name %q", lineNum, key)
+		}
+
+		value, err := parseEnvValue(strings.TrimSpace(line[sep+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+
+		env = append(env, key+"="+value)
+	}
+
+	return env, scanner.Err()
+}
+
+func parseEnvValue(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, "'"):
+		end := strings.IndexByte(v[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated single quote")
+		}
+		return v[1 : end+1], nil
+
+	case strings.HasPrefix(v, `"`):
+		var sb strings.Builder
+		for i := 1; i < len(v); i++ {
+			c := v[i]
+			switch {
+			case c == '"':
+				return sb.String(), nil
+			case c == '\\' && i+1 < len(v):
+				i++
+				switch v[i] {
+				case 'n':
+					sb.WriteByte('\n')
+				case 't':
+					sb.WriteByte('\t')
+				default:
+					sb.WriteByte(v[i])
+				}
+			default:
+				sb.WriteByte(c)
+			}
+		}
+		return "", fmt.Errorf("unterminated double quote")
+
+	default:
+		if idx := strings.Index(v, " #"); idx >= 0 {
+			v = v[:idx]
+		}
+		return strings.TrimSpace(v), nil
+	}
+}
+
+func validEnvName(name string) bool {
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
+
+// envList converts a map of environment variables into a sorted list of
+// KEY=VALUE pairs.
+func envList(env map[string]string) []string {
+	list := make([]string, 0, len(env))
+	for k, v := range env {
+		list = append(list, k+"="+v)
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,71 @@
+package gowatch
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	in := `
+# comment
+FOO=bar
+export GOFLAGS=-mod=vendor
+SPACED = value with spaces # trailing comment
+SINGLE='literal $HOME \n'
+DOUBLE="line\nbreak \"quoted\""
+EMPTY=
+`
+
+	expect := []string{
+		"FOO=bar",
+		"GOFLAGS=-mod=vendor",
+		"SPACED=value with spaces",
+		`SINGLE=literal $HOME \n`,
+		"DOUBLE=line\nbreak \"quoted\"",
+		"EMPTY=",
+	}
+
+	env, err := parseEnvFile(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(env, expect) {
+		t.Errorf("expected %q, got %q", expect, env)
+	}
+}
+
+func TestParseEnvFileErrors(t *testing.T) {
+	tt := []struct {
+		name string
+		in   string
+	}{
+		{"missing value", "FOO"},
+		{"invalid name", "1FOO=bar"},
+		{"unterminated single quote", "FOO='bar"},
+		{"unterminated double quote", `FOO="bar`},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseEnvFile(strings.NewReader(tc.in))
+			if err == nil {
+				t.Errorf("expected error parsing %q", tc.in)
+			}
+		})
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	env := mergeEnv(
+		[]string{"A=1", "B=2"},
+		[]string{"B=3", "C=4"},
+		[]string{"A=5"},
+	)
+
+	expect := []string{"A=5", "B=3", "C=4"}
+	if !reflect.DeepEqual(env, expect) {
+		t.Errorf("expected %q, got %q", expect, env)
+	}
+}
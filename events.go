@@ -0,0 +1,216 @@
+package gowatch
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"mvdan.cc/sh/interp"
+)
+
+// EventType identifies what happened in an Event.
+type EventType int
+
+const (
+	// EventFileBatchDetected is emitted when a batch of file changes
+	// matched one or more file triggers. Files and Steps are set.
+	EventFileBatchDetected EventType = iota
+
+	// EventTriggerStarted is emitted when a step of a trigger sequence
+	// starts running.
+	EventTriggerStarted
+
+	// EventTriggerSucceeded is emitted when a step of a trigger sequence
+	// finished successfully.
+	EventTriggerSucceeded
+
+	// EventTriggerFailed is emitted when a step of a trigger sequence
+	// failed. Error is set.
+	EventTriggerFailed
+
+	// EventTriggerCancelled is emitted when a step of a trigger sequence
+	// was cancelled before it could finish.
+	EventTriggerCancelled
+
+	// EventServiceStarted is emitted every time the script of a service
+	// is started, including restarts.
+	EventServiceStarted
+
+	// EventServiceReady is emitted when the readiness probe of a service
+	// passes.
+	EventServiceReady
+
+	// EventServiceExited is emitted when the script of a service exits on
+	// its own. ExitCode is set, and Error is set if the service failed.
+	EventServiceExited
+
+	// EventServiceRestarting is emitted when a service that exited is
+	// about to be restarted. Delay is set.
+	EventServiceRestarting
+
+	// EventServiceStopped is emitted when a service is stopped.
+	EventServiceStopped
+
+	// EventWatchPaused is emitted when file watching is paused.
+	EventWatchPaused
+
+	// EventWatchResumed is emitted when file watching is resumed.
+	EventWatchResumed
+)
+
+var eventTypeNames = map[EventType]string{
+	EventFileBatchDetected: "file_batch_detected",
+	EventTriggerStarted:    "trigger_started",
+	EventTriggerSucceeded:  "trigger_succeeded",
+	EventTriggerFailed:     "trigger_failed",
+	EventTriggerCancelled:  "trigger_cancelled",
+	EventServiceStarted:    "service_started",
+	EventServiceReady:      "service_ready",
+	EventServiceExited:     "service_exited",
+	EventServiceRestarting: "service_restarting",
+	EventServiceStopped:    "service_stopped",
+	EventWatchPaused:       "watch_paused",
+	EventWatchResumed:      "watch_resumed",
+}
+
+func (t EventType) String() string {
+	if name, ok := eventTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (t EventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (t *EventType) UnmarshalText(text []byte) error {
+	for typ, name := range eventTypeNames {
+		if name == string(text) {
+			*t = typ
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown event type %q", text)
+}
+
+// An Event describes something that happened in a Watcher. Which fields are
+// set depends on the Type of the event.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+
+	// Trigger is the name of the trigger, action, or service the event is
+	// about.
+	Trigger string `json:"trigger,omitempty"`
+
+	// Files holds the absolute paths of changed files.
+	Files []string `json:"files,omitempty"`
+
+	// Steps holds the steps of the trigger sequence that will run.
+	Steps []string `json:"steps,omitempty"`
+
+	// ExitCode holds the exit status of a service's script. It is -1 if
+	// the script stopped without an exit status.
+	ExitCode int `json:"exit_code,omitempty"`
+
+	// Delay holds how long until a service is restarted.
+	Delay time.Duration `json:"delay,omitempty"`
+
+	// Error describes why something failed.
+	Error string `json:"error,omitempty"`
+}
+
+// eventBufferSize is how many events can be queued for a subscriber before
+// new events are dropped.
+const eventBufferSize = 256
+
+// eventHub broadcasts events to subscribers. The zero value is ready to use.
+type eventHub struct {
+	lock sync.Mutex
+	subs map[<-chan Event]chan Event
+}
+
+// Subscribe returns a channel that receives all events published after the
+// call. The channel must be released with Unsubscribe.
+func (h *eventHub) Subscribe() <-chan Event {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.subs == nil {
+		h.subs = make(map[<-chan Event]chan Event)
+	}
+
+	ch := make(chan Event, eventBufferSize)
+	h.subs[ch] = ch
+	return ch
+}
+
+// Unsubscribe stops sending events to ch and closes it.
+func (h *eventHub) Unsubscribe(ch <-chan Event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if sub, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(sub)
+	}
+}
+
+// Publish sends ev to all subscribers. Subscribers that are not keeping up
+// miss the event rather than blocking the publisher.
+func (h *eventHub) Publish(ev Event) {
+	if h == nil {
+		return
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	for _, ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel that receives every Event emitted by the
+// watcher from now on. Events are buffered; if a subscriber falls too far
+// behind, new events are dropped for it rather than blocking the watcher.
+// Call Unsubscribe once the channel is no longer needed.
+func (w *Watcher) Subscribe() <-chan Event {
+	return w.events.Subscribe()
+}
+
+// Unsubscribe stops sending events to a channel returned by Subscribe and
+// closes it.
+func (w *Watcher) Unsubscribe(ch <-chan Event) {
+	w.events.Unsubscribe(ch)
+}
+
+func (w *Watcher) emit(ev Event) {
+	w.events.Publish(ev)
+}
+
+// exitCode returns the exit status held by an error returned from running a
+// script, or -1 if there isn't one.
+func exitCode(err error) int {
+	switch err := err.(type) {
+	case nil:
+		return 0
+	case interp.ExitStatus:
+		return int(err)
+	case interp.ShellExitStatus:
+		return int(err)
+	default:
+		return -1
+	}
+}
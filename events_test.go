@@ -0,0 +1,108 @@
+package gowatch_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/rfratto/gowatch"
+)
+
+func nextEvent(t *testing.T, ch <-chan gowatch.Event) gowatch.Event {
+	t.Helper()
+
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+		return gowatch.Event{}
+	}
+}
+
+func TestSubscribePause(t *testing.T) {
+	w := gowatch.NewWatcher(wd(t), gowatch.Config{})
+
+	ch := w.Subscribe()
+	w.Pause()
+	w.Resume()
+
+	if ev := nextEvent(t, ch); ev.Type != gowatch.EventWatchPaused {
+		t.Errorf("expected event %s, got %s", gowatch.EventWatchPaused, ev.Type)
+	}
+	if ev := nextEvent(t, ch); ev.Type != gowatch.EventWatchResumed {
+		t.Errorf("expected event %s, got %s", gowatch.EventWatchResumed, ev.Type)
+	}
+
+	w.Unsubscribe(ch)
+	if _, ok := <-ch; ok {
+		t.Error("expected channel to be closed after unsubscribing")
+	}
+}
+
+func TestSubscribeTrigger(t *testing.T) {
+	p, err := ioutil.TempDir("", "gowatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(p)
+
+	file := path.Join(p, "main.go")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := gowatch.NewWatcherWithContext(ctx, p, gowatch.Config{
+		Actions: map[string]gowatch.Script{
+			"ok":   {Run: "true"},
+			"fail": {Run: "false"},
+		},
+		FileTriggers: []gowatch.FileTrigger{
+			{Include: []string{"*.go"}, Triggers: []gowatch.Step{{Trigger: "ok"}, {Trigger: "fail"}}},
+		},
+	})
+
+	ch := w.Subscribe()
+	defer w.Unsubscribe(ch)
+
+	go w.Start()
+
+	// Keep writing to the file until the watcher notices.
+	var ev gowatch.Event
+	for detected := false; !detected; {
+		ioutil.WriteFile(file, []byte("package main"), 0644)
+
+		select {
+		case ev = <-ch:
+			detected = ev.Type == gowatch.EventFileBatchDetected
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+
+	if len(ev.Files) != 1 || ev.Files[0] != file {
+		t.Errorf("expected changed files %v, got %v", []string{file}, ev.Files)
+	}
+
+	expect := []struct {
+		typ     gowatch.EventType
+		trigger string
+	}{
+		{gowatch.EventTriggerStarted, "ok"},
+		{gowatch.EventTriggerSucceeded, "ok"},
+		{gowatch.EventTriggerStarted, "fail"},
+		{gowatch.EventTriggerFailed, "fail"},
+	}
+
+	for _, e := range expect {
+		ev := nextEvent(t, ch)
+		if ev.Type != e.typ || ev.Trigger != e.trigger {
+			t.Fatalf("expected %s %s, got %s %s", e.typ, e.trigger, ev.Type, ev.Trigger)
+		}
+	}
+}
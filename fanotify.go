@@ -0,0 +1,10 @@
+// +build !linux
+
+package gowatch
+
+import "errors"
+
+// newFanotifyBackend returns an error, since fanotify only exists on Linux.
+func newFanotifyBackend(dir string) (Backend, error) {
+	return nil, errors.New("fanotify is only available on Linux")
+}
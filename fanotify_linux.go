@@ -0,0 +1,385 @@
+// +build linux
+
+package gowatch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/sys/unix"
+)
+
+// fanotify flags and event types, defined here since older versions of
+// golang.org/x/sys lack some of them.
+const (
+	fanClassNotif     = 0x0
+	fanCloexec        = 0x1
+	fanNonblock       = 0x2
+	fanReportDFIDName = 0xc00 // FAN_REPORT_DIR_FID | FAN_REPORT_NAME
+
+	fanMarkAdd        = 0x1
+	fanMarkFilesystem = 0x100
+
+	fanModify    = 0x2
+	fanAttrib    = 0x4
+	fanMovedFrom = 0x40
+	fanMovedTo   = 0x80
+	fanCreate    = 0x100
+	fanDelete    = 0x200
+	fanQOverflow = 0x4000
+	fanOnDir     = 0x40000000
+
+	fanEventInfoTypeDFIDName = 2
+	fanEventInfoTypeDFID     = 3
+
+	maxHandleSize = 128
+)
+
+// fanotifyEventMetadata is struct fanotify_event_metadata.
+type fanotifyEventMetadata struct {
+	EventLen    uint32
+	Vers        uint8
+	Reserved    uint8
+	MetadataLen uint16
+	Mask        uint64
+	Fd          int32
+	Pid         int32
+}
+
+// fanotifyEventInfoFID is struct fanotify_event_info_fid without the file
+// handle that follows it.
+type fanotifyEventInfoFID struct {
+	InfoType uint8
+	Pad      uint8
+	Len      uint16
+	Fsid     [2]int32
+}
+
+// fileHandle is struct file_handle without the handle that follows it.
+type fileHandle struct {
+	Bytes uint32
+	Type  int32
+}
+
+// fanotifyBackend is a Backend that marks whole filesystems with fanotify,
+// rather than adding a watch for every directory like inotify. Events are
+// reported with the file handle of their directory, which is looked up in
+// the handles of the watched directories, dropping events for every other
+// directory.
+type fanotifyBackend struct {
+	fd     int
+	wake   [2]int
+	events chan fsnotify.Event
+	errors chan error
+	stop   chan struct{}
+	done   chan struct{}
+	close  sync.Once
+
+	lock    sync.Mutex
+	marked  map[[2]int32]bool
+	dirs    map[string]string // handle key to path
+	handles map[string]string // path to handle key
+}
+
+// newFanotifyBackend creates a fanotify backend and marks the filesystem of
+// dir. It fails without CAP_SYS_ADMIN or on kernels older than Linux 5.9,
+// which lack FAN_REPORT_DFID_NAME.
+func newFanotifyBackend(dir string) (Backend, error) {
+	fd, err := unix.FanotifyInit(fanClassNotif|fanCloexec|fanNonblock|fanReportDFIDName, unix.O_RDONLY|unix.O_LARGEFILE)
+	if err == unix.EPERM {
+		return nil, fmt.Errorf("fanotify needs CAP_SYS_ADMIN")
+	} else if err != nil {
+		return nil, fmt.Errorf("fanotify_init: %v", err)
+	}
+
+	b := &fanotifyBackend{
+		fd:      fd,
+		events:  make(chan fsnotify.Event),
+		errors:  make(chan error),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		marked:  make(map[[2]int32]bool),
+		dirs:    make(map[string]string),
+		handles: make(map[string]string),
+	}
+
+	if err := unix.Pipe2(b.wake[:], unix.O_CLOEXEC|unix.O_NONBLOCK); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	if _, err := b.mark(dir); err != nil {
+		unix.Close(fd)
+		unix.Close(b.wake[0])
+		unix.Close(b.wake[1])
+		if err == unix.EPERM {
+			return nil, fmt.Errorf("fanotify needs CAP_SYS_ADMIN")
+		}
+		return nil, fmt.Errorf("fanotify_mark: %v", err)
+	}
+
+	go b.read()
+	return b, nil
+}
+
+// mark marks the filesystem of dir once and returns its id.
+func (b *fanotifyBackend) mark(dir string) ([2]int32, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return [2]int32{}, err
+	}
+	fsid := st.Fsid.Val
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.marked[fsid] {
+		return fsid, nil
+	}
+
+	mask := uint64(fanCreate | fanDelete | fanModify | fanAttrib | fanMovedFrom | fanMovedTo | fanOnDir)
+	if err := unix.FanotifyMark(b.fd, fanMarkAdd|fanMarkFilesystem, mask, unix.AT_FDCWD, dir); err != nil {
+		return fsid, err
+	}
+	b.marked[fsid] = true
+	return fsid, nil
+}
+
+func (b *fanotifyBackend) Add(dir string) error {
+	fsid, err := b.mark(dir)
+	if err != nil {
+		return err
+	}
+
+	handle, err := nameToHandle(dir)
+	if err != nil {
+		return err
+	}
+	key := handleKey(fsid, handle)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if old, ok := b.handles[dir]; ok {
+		delete(b.dirs, old)
+	}
+	b.dirs[key] = dir
+	b.handles[dir] = key
+	return nil
+}
+
+func (b *fanotifyBackend) Remove(dir string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	key, ok := b.handles[dir]
+	if !ok {
+		return fmt.Errorf("%s is not watched", dir)
+	}
+	delete(b.handles, dir)
+	if b.dirs[key] == dir {
+		delete(b.dirs, key)
+	}
+	return nil
+}
+
+func (b *fanotifyBackend) Events() <-chan fsnotify.Event { return b.events }
+func (b *fanotifyBackend) Errors() <-chan error          { return b.errors }
+
+func (b *fanotifyBackend) Close() error {
+	b.close.Do(func() {
+		close(b.stop)
+		unix.Write(b.wake[1], []byte{0})
+		<-b.done
+		unix.Close(b.fd)
+		unix.Close(b.wake[0])
+		unix.Close(b.wake[1])
+	})
+	return nil
+}
+
+// read reads events until Close is called. It polls the fanotify descriptor
+// together with a pipe that Close writes to, since a blocking read can't be
+// interrupted.
+func (b *fanotifyBackend) read() {
+	defer close(b.done)
+
+	buf := make([]byte, 64*1024)
+	for {
+		fds := []unix.PollFd{
+			{Fd: int32(b.fd), Events: unix.POLLIN},
+			{Fd: int32(b.wake[0]), Events: unix.POLLIN},
+		}
+		if _, err := unix.Poll(fds, -1); err == unix.EINTR {
+			continue
+		} else if err != nil {
+			b.sendError(err)
+			return
+		}
+		if fds[1].Revents != 0 {
+			return
+		}
+
+		n, err := unix.Read(b.fd, buf)
+		if err == unix.EAGAIN || err == unix.EINTR {
+			continue
+		} else if err != nil {
+			b.sendError(err)
+			return
+		}
+
+		for _, ev := range b.parse(buf[:n]) {
+			select {
+			case b.events <- ev:
+			case <-b.stop:
+				return
+			}
+		}
+	}
+}
+
+func (b *fanotifyBackend) sendError(err error) {
+	select {
+	case b.errors <- err:
+	default:
+	}
+}
+
+// parse returns the file events of watched directories in buf.
+func (b *fanotifyBackend) parse(buf []byte) []fsnotify.Event {
+	var events []fsnotify.Event
+
+	for len(buf) >= int(unsafe.Sizeof(fanotifyEventMetadata{})) {
+		meta := (*fanotifyEventMetadata)(unsafe.Pointer(&buf[0]))
+		if meta.EventLen < uint32(meta.MetadataLen) || int(meta.EventLen) > len(buf) {
+			break
+		}
+		info := buf[meta.MetadataLen:meta.EventLen]
+		buf = buf[meta.EventLen:]
+
+		if meta.Fd >= 0 {
+			unix.Close(int(meta.Fd))
+		}
+		if meta.Mask&fanQOverflow != 0 {
+			b.sendError(fsnotify.ErrEventOverflow)
+			continue
+		}
+
+		file, ok := b.resolve(info)
+		if !ok {
+			continue
+		}
+		if op := fanotifyOp(meta.Mask); op != 0 {
+			events = append(events, fsnotify.Event{Name: file, Op: op})
+		}
+	}
+
+	return events
+}
+
+// resolve returns the path of the file an event is about from its info
+// records. ok is false if the directory of the file isn't watched.
+func (b *fanotifyBackend) resolve(info []byte) (file string, ok bool) {
+	const infoSize = int(unsafe.Sizeof(fanotifyEventInfoFID{}))
+	const handleSize = int(unsafe.Sizeof(fileHandle{}))
+
+	for len(info) >= infoSize+handleSize {
+		hdr := (*fanotifyEventInfoFID)(unsafe.Pointer(&info[0]))
+		if int(hdr.Len) < infoSize+handleSize || int(hdr.Len) > len(info) {
+			return "", false
+		}
+		record := info[:hdr.Len]
+		info = info[hdr.Len:]
+
+		if hdr.InfoType != fanEventInfoTypeDFIDName && hdr.InfoType != fanEventInfoTypeDFID {
+			continue
+		}
+
+		fh := (*fileHandle)(unsafe.Pointer(&record[infoSize]))
+		end := infoSize + handleSize + int(fh.Bytes)
+		if end > len(record) {
+			return "", false
+		}
+
+		b.lock.Lock()
+		dir, watched := b.dirs[handleKey(hdr.Fsid, record[infoSize:end])]
+		b.lock.Unlock()
+		if !watched {
+			return "", false
+		}
+
+		name := record[end:]
+		for i, c := range name {
+			if c == 0 {
+				name = name[:i]
+				break
+			}
+		}
+		if len(name) == 0 || string(name) == "." {
+			return dir, true
+		}
+		return filepath.Join(dir, string(name)), true
+	}
+
+	return "", false
+}
+
+// fanotifyOp returns the fsnotify operation of a fanotify event mask. Like
+// inotify through fsnotify, files moved into a directory are reported as
+// created and files moved out of it as renamed.
+func fanotifyOp(mask uint64) fsnotify.Op {
+	var op fsnotify.Op
+	if mask&(fanCreate|fanMovedTo) != 0 {
+		op |= fsnotify.Create
+	}
+	if mask&fanModify != 0 {
+		op |= fsnotify.Write
+	}
+	if mask&fanDelete != 0 {
+		op |= fsnotify.Remove
+	}
+	if mask&fanMovedFrom != 0 {
+		op |= fsnotify.Rename
+	}
+	if mask&fanAttrib != 0 {
+		op |= fsnotify.Chmod
+	}
+	return op
+}
+
+// nameToHandle returns the struct file_handle of path, as reported in the
+// info records of fanotify events.
+func nameToHandle(path string) ([]byte, error) {
+	p, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	const handleSize = int(unsafe.Sizeof(fileHandle{}))
+	buf := make([]byte, handleSize+maxHandleSize)
+	(*fileHandle)(unsafe.Pointer(&buf[0])).Bytes = maxHandleSize
+
+	var mountID int32
+	dirfd := unix.AT_FDCWD
+	_, _, errno := unix.Syscall6(
+		unix.SYS_NAME_TO_HANDLE_AT,
+		uintptr(dirfd),
+		uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&buf[0])),
+		uintptr(unsafe.Pointer(&mountID)),
+		0, 0,
+	)
+	if errno != 0 {
+		return nil, &os.PathError{Op: "name_to_handle_at", Path: path, Err: errno}
+	}
+
+	fh := (*fileHandle)(unsafe.Pointer(&buf[0]))
+	return buf[:handleSize+int(fh.Bytes)], nil
+}
+
+// handleKey returns a map key identifying a file handle on a filesystem.
+func handleKey(fsid [2]int32, handle []byte) string {
+	return fmt.Sprintf("%d:%d:%x", fsid[0], fsid[1], handle)
+}
@@ -0,0 +1,107 @@
+package gowatch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fanotifyEvent returns the bytes of an event reported with a DFID_NAME info
+// record.
+func fanotifyEvent(mask uint64, fsid [2]int32, handle []byte, name string) []byte {
+	const metaSize = int(unsafe.Sizeof(fanotifyEventMetadata{}))
+	const infoSize = int(unsafe.Sizeof(fanotifyEventInfoFID{}))
+
+	infoLen := infoSize + len(handle) + len(name) + 1
+	infoLen += (4 - infoLen%4) % 4
+	buf := make([]byte, metaSize+infoLen)
+
+	*(*fanotifyEventMetadata)(unsafe.Pointer(&buf[0])) = fanotifyEventMetadata{
+		EventLen:    uint32(len(buf)),
+		Vers:        3,
+		MetadataLen: uint16(metaSize),
+		Mask:        mask,
+		Fd:          -1,
+	}
+	*(*fanotifyEventInfoFID)(unsafe.Pointer(&buf[metaSize])) = fanotifyEventInfoFID{
+		InfoType: fanEventInfoTypeDFIDName,
+		Len:      uint16(infoLen),
+		Fsid:     fsid,
+	}
+	copy(buf[metaSize+infoSize:], handle)
+	copy(buf[metaSize+infoSize+len(handle):], name)
+	return buf
+}
+
+func TestFanotifyParse(t *testing.T) {
+	fsid := [2]int32{1, 2}
+	watched := []byte{4, 0, 0, 0, 1, 0, 0, 0, 10, 0, 0, 0}
+	other := []byte{4, 0, 0, 0, 1, 0, 0, 0, 11, 0, 0, 0}
+
+	b := &fanotifyBackend{
+		dirs:    map[string]string{handleKey(fsid, watched): "/src"},
+		handles: map[string]string{"/src": handleKey(fsid, watched)},
+	}
+
+	var buf []byte
+	buf = append(buf, fanotifyEvent(fanCreate, fsid, watched, "a.go")...)
+	buf = append(buf, fanotifyEvent(fanModify, fsid, other, "b.go")...)
+	buf = append(buf, fanotifyEvent(fanMovedFrom|fanOnDir, fsid, watched, "pkg")...)
+	buf = append(buf, fanotifyEvent(fanAttrib|fanOnDir, fsid, watched, ".")...)
+
+	expect := []fsnotify.Event{
+		{Name: "/src/a.go", Op: fsnotify.Create},
+		{Name: "/src/pkg", Op: fsnotify.Rename},
+		{Name: "/src", Op: fsnotify.Chmod},
+	}
+
+	events := b.parse(buf)
+	if len(events) != len(expect) {
+		t.Fatalf("expected events %v, got %v", expect, events)
+	}
+	for i := range expect {
+		if events[i] != expect[i] {
+			t.Errorf("expected event %v, got %v", expect[i], events[i])
+		}
+	}
+}
+
+func TestFanotifyBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gowatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, _ = filepath.EvalSymlinks(dir)
+
+	b, err := newFanotifyBackend(dir)
+	if err != nil {
+		t.Skipf("fanotify unavailable: %v", err)
+	}
+	defer b.Close()
+
+	if err := b.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte("package a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		select {
+		case ev := <-b.Events():
+			if ev.Name == filepath.Join(dir, "a.go") && ev.Op&fsnotify.Create != 0 {
+				return
+			}
+		case err := <-b.Errors():
+			t.Fatal(err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for create event")
+		}
+	}
+}
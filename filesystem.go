@@ -0,0 +1,14 @@
+// +build !linux
+
+package gowatch
+
+// readMountInfo returns no mounts, since they are only inspected on Linux.
+// Every path then uses the native file events.
+func readMountInfo() ([]mountInfo, error) {
+	return nil, nil
+}
+
+// inotifyWatchLimit returns false, since inotify only exists on Linux.
+func inotifyWatchLimit() (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,87 @@
+// +build linux
+
+package gowatch
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readMountInfo returns the mounts of the current mount namespace.
+func readMountInfo() ([]mountInfo, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []mountInfo
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if m, ok := parseMountInfo(s.Text()); ok {
+			mounts = append(mounts, m)
+		}
+	}
+	return mounts, s.Err()
+}
+
+// parseMountInfo parses a line of /proc/self/mountinfo, which looks like:
+//
+//	36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw
+//
+// The optional fields before the - separator vary in number.
+func parseMountInfo(line string) (m mountInfo, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 5 {
+		return m, false
+	}
+	m.mountPoint = unescapeMountInfo(fields[4])
+
+	for i := 5; i < len(fields); i++ {
+		if fields[i] != "-" {
+			continue
+		}
+		if i+2 >= len(fields) {
+			return m, false
+		}
+		m.fsType = fields[i+1]
+		m.source = unescapeMountInfo(fields[i+2])
+		return m, true
+	}
+	return m, false
+}
+
+// unescapeMountInfo decodes the octal escapes, such as \040 for a space,
+// used by the kernel in mountinfo paths.
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// inotifyWatchLimit returns the inotify watch limit of the current user.
+func inotifyWatchLimit() (int, bool) {
+	b, err := ioutil.ReadFile("/proc/sys/fs/inotify/max_user_watches")
+	if err != nil {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	return n, err == nil
+}
@@ -0,0 +1,42 @@
+package gowatch
+
+import "testing"
+
+func TestParseMountInfo(t *testing.T) {
+	tt := []struct {
+		line   string
+		expect mountInfo
+		ok     bool
+	}{
+		{
+			"36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue",
+			mountInfo{mountPoint: "/mnt2", fsType: "ext3", source: "/dev/root"},
+			true,
+		},
+		{
+			"99 36 0:52 / /home/my\\040code rw,relatime - nfs4 server:/export rw,vers=4.2",
+			mountInfo{mountPoint: "/home/my code", fsType: "nfs4", source: "server:/export"},
+			true,
+		},
+		{"36 35 98:0 /mnt1 /mnt2 rw,noatime", mountInfo{mountPoint: "/mnt2"}, false},
+	}
+
+	for _, tc := range tt {
+		m, ok := parseMountInfo(tc.line)
+		if ok != tc.ok || (ok && m != tc.expect) {
+			t.Errorf("parsing %q: expected %+v (%v), got %+v (%v)", tc.line, tc.expect, tc.ok, m, ok)
+		}
+	}
+
+	mounts := []mountInfo{
+		{mountPoint: "/", fsType: "overlay"},
+		{mountPoint: "/home", fsType: "ext4"},
+		{mountPoint: "/home/my code", fsType: "nfs4"},
+	}
+	if m, _ := mountFor(mounts, "/home/my code/src"); m.fsType != "nfs4" {
+		t.Errorf("expected nfs4 mount, got %+v", m)
+	}
+	if m, _ := mountFor(mounts, "/home/my codex"); m.fsType != "ext4" {
+		t.Errorf("expected ext4 mount, got %+v", m)
+	}
+}
@@ -0,0 +1,198 @@
+package gowatch
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar"
+)
+
+// ignoreFileNames holds the names of the files that list patterns of files
+// to ignore, in the order they are read within a directory. Rules in later
+// files take precedence.
+var ignoreFileNames = []string{".gitignore", ".ignore", ".gowatchignore"}
+
+// isIgnoreFile returns whether file holds ignore rules for the tree at root.
+func isIgnoreFile(root string, file string) bool {
+	return contains(ignoreFileNames, filepath.Base(file)) ||
+		file == filepath.Join(root, ".git", "info", "exclude")
+}
+
+// An ignoreRule is a single pattern of an ignore file.
+type ignoreRule struct {
+	// The directory holding the ignore file. The pattern only applies to
+	// paths within it.
+	base string
+
+	pattern string
+
+	// negate re-includes paths matched by earlier rules. dirOnly only
+	// matches directories. anchored patterns are matched against the path
+	// relative to base rather than against the name of a file.
+	negate, dirOnly, anchored bool
+}
+
+// parseIgnoreRule parses a line of an ignore file using gitignore syntax.
+// ok is false for blank lines and comments.
+func parseIgnoreRule(base string, line string) (r ignoreRule, ok bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return r, false
+	}
+
+	r.base = base
+	switch {
+	case strings.HasPrefix(line, "!"):
+		r.negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, `\!`), strings.HasPrefix(line, `\#`):
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+
+	if strings.HasPrefix(line, "/") {
+		r.anchored = true
+		line = line[1:]
+	} else if strings.Contains(line, "/") {
+		r.anchored = true
+	}
+
+	r.pattern = line
+	return r, line != ""
+}
+
+func (r ignoreRule) match(file string, dir bool) bool {
+	if r.dirOnly && !dir {
+		return false
+	}
+
+	rel, err := filepath.Rel(r.base, file)
+	if err != nil || !isSubpath(rel) {
+		return false
+	}
+
+	rel = filepath.ToSlash(rel)
+	if !r.anchored {
+		rel = path.Base(rel)
+	}
+
+	ok, _ := doublestar.Match(r.pattern, rel)
+	return ok
+}
+
+// isSubpath returns whether the relative path rel points below the
+// directory it is relative to.
+func isSubpath(rel string) bool {
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// ignoreRules holds the rules of every ignore file in a directory tree. A nil
+// *ignoreRules ignores nothing.
+type ignoreRules struct {
+	root  string
+	rules []ignoreRule
+}
+
+// loadIgnoreRules reads .git/info/exclude and the ignore files in every
+// directory under root that isn't ignored itself.
+func loadIgnoreRules(root string) *ignoreRules {
+	r := &ignoreRules{root: root}
+	r.read(root, filepath.Join(root, ".git", "info", "exclude"))
+
+	filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.IsDir() {
+			return nil
+		}
+		if p != root && (fi.Name() == ".git" || r.ignored(p)) {
+			return filepath.SkipDir
+		}
+
+		for _, name := range ignoreFileNames {
+			r.read(p, filepath.Join(p, name))
+		}
+		return nil
+	})
+
+	return r
+}
+
+// read adds the rules of the ignore file at file, whose patterns are
+// relative to base. Missing files are skipped.
+func (r *ignoreRules) read(base string, file string) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if rule, ok := parseIgnoreRule(base, s.Text()); ok {
+			r.rules = append(r.rules, rule)
+		}
+	}
+}
+
+// ignored returns whether file is ignored. Like git, a file is ignored when
+// any of its parent directories is, even if a later rule would re-include
+// the file itself.
+func (r *ignoreRules) ignored(file string) bool {
+	if r == nil || len(r.rules) == 0 {
+		return false
+	}
+
+	rel, err := filepath.Rel(r.root, file)
+	if err != nil || !isSubpath(rel) {
+		return false
+	}
+
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i := range parts {
+		p := filepath.Join(r.root, filepath.Join(parts[:i+1]...))
+		dir := i < len(parts)-1 || isDir(p)
+		if r.match(p, dir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// match returns whether the last rule matching file ignores it.
+func (r *ignoreRules) match(file string, dir bool) bool {
+	ignored := false
+	for _, rule := range r.rules {
+		if rule.match(file, dir) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// ignoreRules returns the rules of the ignore files in Directory, for the
+// file triggers that use them. The rules are loaded once and kept until an
+// ignore file changes.
+func (w *Watcher) ignoreRules() *ignoreRules {
+	w.ignoreLock.Lock()
+	defer w.ignoreLock.Unlock()
+
+	if w.ignore == nil {
+		w.ignore = loadIgnoreRules(w.Directory)
+	}
+	return w.ignore
+}
+
+// resetIgnoreRules has the ignore rules loaded again the next time they are
+// used.
+func (w *Watcher) resetIgnoreRules() {
+	w.ignoreLock.Lock()
+	defer w.ignoreLock.Unlock()
+	w.ignore = nil
+}
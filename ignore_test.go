@@ -0,0 +1,120 @@
+package gowatch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		file := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestIgnoreRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gowatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTree(t, dir, map[string]string{
+		".git/info/exclude":       "*.swp\n",
+		".gitignore":              "# build output\n/build\nnode_modules/\n*.log\n!keep.log\n",
+		"main.go":                 "",
+		"main.go.swp":             "",
+		"debug.log":               "",
+		"keep.log":                "",
+		"build/out":               "",
+		"node_modules/a.js":       "",
+		"node_modules/.gitignore": "!*.js\n",
+		"src/build/gen.go":        "",
+		"src/node_modules":        "",
+		"src/.gowatchignore":      "gen/**\n!debug.log\n",
+		"src/gen/a/b.go":          "",
+		"src/debug.log":           "",
+	})
+
+	r := loadIgnoreRules(dir)
+
+	tt := []struct {
+		file    string
+		ignored bool
+	}{
+		{"main.go", false},
+		{"main.go.swp", true},
+		{"debug.log", true},
+		{"keep.log", false},
+		{"build", true},
+		{"build/out", true},
+
+		// Files can't be re-included when their directory is ignored.
+		{"node_modules/a.js", true},
+
+		// Anchored patterns only match relative to their ignore file, and
+		// directory patterns only match directories.
+		{"src/build/gen.go", false},
+		{"src/node_modules", false},
+
+		// Ignore files in subdirectories take precedence.
+		{"src/gen/a/b.go", true},
+		{"src/debug.log", false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.file, func(t *testing.T) {
+			file := filepath.Join(dir, filepath.FromSlash(tc.file))
+			if ignored := r.ignored(file); ignored != tc.ignored {
+				t.Errorf("expected ignored to be %v, got %v", tc.ignored, ignored)
+			}
+		})
+	}
+}
+
+func TestFileTriggerIgnoreFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gowatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTree(t, dir, map[string]string{
+		".gitignore":        "vendor/\n",
+		"main.go":           "",
+		"vendor/lib/lib.go": "",
+	})
+
+	no := false
+	w := NewWatcher(dir, Config{
+		UseIgnoreFiles: true,
+		FileTriggers: []FileTrigger{
+			{Include: []string{"**/*.go"}, Triggers: []Step{{Trigger: "build"}}},
+			{Include: []string{"**/*.go"}, Triggers: []Step{{Trigger: "vendor"}}, UseIgnoreFiles: &no},
+		},
+	})
+
+	vendored := filepath.Join(dir, "vendor", "lib", "lib.go")
+	triggers, err := w.MatchingTriggers(vendored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(triggers) != 1 || triggers[0].Triggers[0].Trigger != "vendor" {
+		t.Errorf("expected only the trigger not using ignore files to match, got %+v", triggers)
+	}
+
+	triggers, err = w.MatchingTriggers(filepath.Join(dir, "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(triggers) != 2 {
+		t.Errorf("expected both triggers to match, got %+v", triggers)
+	}
+}
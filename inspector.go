@@ -0,0 +1,142 @@
+package gowatch
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+)
+
+// A mountInfo is an entry of /proc/self/mountinfo.
+type mountInfo struct {
+	mountPoint string
+	fsType     string
+	source     string
+}
+
+// mountFor returns the mount holding path: the one with the longest mount
+// point that path is in. Later mounts on the same mount point hide earlier
+// ones.
+func mountFor(mounts []mountInfo, path string) (m mountInfo, ok bool) {
+	for _, mi := range mounts {
+		if isParent(mi.mountPoint, path) && (!ok || len(mi.mountPoint) >= len(m.mountPoint)) {
+			m, ok = mi, true
+		}
+	}
+	return m, ok
+}
+
+// An fsStrategy is how changes to the files of a filesystem are watched for.
+type fsStrategy string
+
+const (
+	// strategyNative relies on the file events of the operating system.
+	strategyNative fsStrategy = "native"
+
+	// strategyParents uses the file events of the operating system, but
+	// also watches every parent of a watched directory up to its mount
+	// point. The osxfs and grpcfuse mounts of Docker Desktop only report
+	// changes to a directory when its parents are watched too.
+	strategyParents fsStrategy = "parents"
+
+	// strategyPoll polls for changes. Network filesystems and the shared
+	// folders of VMs and containers don't report changes made on another
+	// machine or on the host.
+	strategyPoll fsStrategy = "poll"
+)
+
+// classifyFilesystem returns the strategy for a type of filesystem, as
+// listed in /proc/self/mountinfo.
+func classifyFilesystem(fsType string) fsStrategy {
+	switch fsType {
+	case "fuse.osxfs", "fuse.grpcfuse":
+		return strategyParents
+	case "nfs", "nfs4", "cifs", "smb3", "smbfs", "9p", "virtiofs", "vboxsf",
+		"fuse.sshfs", "fuse.vmhgfs-fuse":
+		return strategyPoll
+	default:
+		return strategyNative
+	}
+}
+
+func (s fsStrategy) String() string {
+	switch s {
+	case strategyParents:
+		return "watching parent directories up to the mount point"
+	case strategyPoll:
+		return "polling for changes"
+	default:
+		return "using native file events"
+	}
+}
+
+// An fsInspector picks the strategy to watch each directory with, based on
+// the filesystem the directory is on.
+type fsInspector struct {
+	mounts []mountInfo
+	debug  io.Writer
+
+	lock     sync.Mutex
+	reported map[string]bool
+}
+
+func newFSInspector(debug io.Writer) *fsInspector {
+	mounts, err := readMountInfo()
+	if err != nil {
+		fmt.Fprintf(debug, "could not read mounts (%v); using native file events\n", err)
+	}
+
+	return &fsInspector{
+		mounts:   mounts,
+		debug:    debug,
+		reported: make(map[string]bool),
+	}
+}
+
+// strategy returns the strategy for dir and the mount it is on. The
+// decision is reported in the debug output the first time a mount is seen.
+// A nil *fsInspector always uses native file events.
+func (i *fsInspector) strategy(dir string) (fsStrategy, mountInfo) {
+	if i == nil {
+		return strategyNative, mountInfo{}
+	}
+
+	m, ok := mountFor(i.mounts, dir)
+	if !ok {
+		return strategyNative, m
+	}
+	s := classifyFilesystem(m.fsType)
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if !i.reported[m.mountPoint] {
+		i.reported[m.mountPoint] = true
+		fmt.Fprintf(i.debug, "%s is on %s (%s); %s\n", m.mountPoint, m.fsType, m.source, s)
+	}
+
+	return s, m
+}
+
+// expand returns dirs with the parents of directories that use
+// strategyParents added.
+func (i *fsInspector) expand(dirs []string) []string {
+	expanded := append([]string{}, dirs...)
+
+	for _, d := range dirs {
+		s, m := i.strategy(d)
+		if s != strategyParents {
+			continue
+		}
+
+		for parent := d; parent != m.mountPoint; {
+			next := filepath.Dir(parent)
+			if next == parent {
+				break
+			}
+			parent = next
+			expanded = append(expanded, parent)
+		}
+	}
+
+	return uniqueStringSlice(expanded)
+}
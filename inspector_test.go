@@ -0,0 +1,41 @@
+package gowatch
+
+import (
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestFSInspector(t *testing.T) {
+	i := &fsInspector{
+		mounts: []mountInfo{
+			{mountPoint: "/", fsType: "overlay", source: "overlay"},
+			{mountPoint: "/src", fsType: "fuse.grpcfuse", source: "grpcfuse"},
+			{mountPoint: "/nfs", fsType: "nfs4", source: "server:/export"},
+		},
+		debug:    ioutil.Discard,
+		reported: make(map[string]bool),
+	}
+
+	tt := []struct {
+		dir    string
+		expect fsStrategy
+	}{
+		{"/app", strategyNative},
+		{"/src/app", strategyParents},
+		{"/nfs/app", strategyPoll},
+	}
+	for _, tc := range tt {
+		if s, _ := i.strategy(tc.dir); s != tc.expect {
+			t.Errorf("expected strategy %s for %s, got %s", tc.expect, tc.dir, s)
+		}
+	}
+
+	dirs := i.expand([]string{"/app/a", "/src/app/lib", "/nfs/app"})
+	sort.Strings(dirs)
+	expect := []string{"/app/a", "/nfs/app", "/src", "/src/app", "/src/app/lib"}
+	if !reflect.DeepEqual(dirs, expect) {
+		t.Errorf("expected dirs %v, got %v", expect, dirs)
+	}
+}
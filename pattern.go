@@ -0,0 +1,186 @@
+package gowatch
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar"
+)
+
+// A pattern is an include or exclude pattern of a file trigger.
+type pattern struct {
+	// The pattern as an absolute glob using / as separator.
+	glob string
+
+	// exclude is set for patterns that remove paths rather than add them.
+	// dirOnly is set for patterns that end in a / and so only match
+	// directories.
+	exclude, dirOnly bool
+}
+
+// matchPath returns whether the glob of p matches file itself.
+func (p pattern) matchPath(file string, dir bool) bool {
+	if p.dirOnly && !dir {
+		return false
+	}
+
+	ok, _ := doublestar.Match(p.glob, filepath.ToSlash(file))
+	return ok
+}
+
+// match returns whether p applies to file. An include pattern applies to the
+// paths it matches and to the files directly within directories it matches.
+// An exclude pattern applies to the paths it matches and to everything below
+// them, comparing whole path segments, so excluding foo doesn't exclude
+// foobar.go.
+func (p pattern) match(file string, dir bool) bool {
+	if !p.exclude {
+		return p.matchPath(file, dir) || (!dir && p.matchPath(filepath.Dir(file), true))
+	}
+
+	for {
+		if p.matchPath(file, dir) {
+			return true
+		}
+
+		parent := filepath.Dir(file)
+		if parent == file {
+			return false
+		}
+		file, dir = parent, true
+	}
+}
+
+// A patternList is an ordered list of patterns. A path is included when the
+// last pattern that applies to it is an include pattern, so later patterns
+// can include paths below an excluded directory again.
+type patternList []pattern
+
+// compilePatterns returns the pattern list for the include and exclude
+// patterns of a file trigger. Include patterns come first, followed by exclude
+// patterns, each in the order they are listed. A pattern starting with ! does
+// the opposite of the list it is in: it excludes paths when listed in include
+// and includes them again when listed in exclude.
+func compilePatterns(root string, include []string, exclude []string) patternList {
+	var list patternList
+
+	add := func(raw string, exclude bool) {
+		if strings.HasPrefix(raw, "!") {
+			raw = raw[1:]
+			exclude = !exclude
+		}
+		if raw == "" {
+			return
+		}
+
+		glob := makeAbsolute(root, []string{raw})[0]
+		list = append(list, pattern{
+			glob:    filepath.ToSlash(strings.TrimSuffix(glob, "/")),
+			exclude: exclude,
+			dirOnly: strings.HasSuffix(raw, "/"),
+		})
+	}
+
+	for _, raw := range include {
+		add(raw, false)
+	}
+	for _, raw := range exclude {
+		add(raw, true)
+	}
+
+	return list
+}
+
+// match returns whether file is included by the pattern list. It only looks
+// at the path, so file doesn't have to exist; dir tells whether it is a
+// directory.
+func (l patternList) match(file string, dir bool) bool {
+	included := false
+	for _, p := range l {
+		if p.match(file, dir) {
+			included = !p.exclude
+		}
+	}
+	return included
+}
+
+// expand returns the existing paths included by the pattern list.
+func (l patternList) expand() []string {
+	matches := []string{}
+
+	for _, p := range l {
+		if p.exclude || !filepath.IsAbs(p.glob) {
+			continue
+		}
+
+		mm, _ := doublestar.Glob(p.glob)
+		for _, m := range mm {
+			if dir := isDir(m); (!p.dirOnly || dir) && l.match(m, dir) {
+				matches = append(matches, m)
+			}
+		}
+	}
+
+	return matches
+}
+
+// base returns the directory that every path matched by p is in: the part
+// of the glob before its first wildcard.
+func (p pattern) base() string {
+	segments := strings.Split(p.glob, "/")
+	for i, seg := range segments {
+		if strings.ContainsAny(seg, `*?[{\`) {
+			return filepath.FromSlash(strings.Join(segments[:i], "/"))
+		}
+	}
+	return filepath.Dir(filepath.FromSlash(p.glob))
+}
+
+// canContain returns whether p could match files within dir or below it,
+// without looking at the files on disk.
+func (p pattern) canContain(dir string) bool {
+	return matchPrefix(strings.Split(p.glob, "/"), strings.Split(filepath.ToSlash(dir), "/"))
+}
+
+// matchPrefix returns whether the leading segments of a glob match every
+// segment of a path.
+func matchPrefix(glob []string, segments []string) bool {
+	switch {
+	case len(segments) == 0:
+		return true
+	case len(glob) == 0:
+		return false
+	case glob[0] == "**":
+		return matchPrefix(glob[1:], segments) || matchPrefix(glob, segments[1:])
+	}
+
+	ok, _ := doublestar.Match(glob[0], segments[0])
+	return ok && matchPrefix(glob[1:], segments[1:])
+}
+
+// couldInclude returns whether the pattern list could include files within
+// dir or below it, which makes dir worth watching.
+func (l patternList) couldInclude(dir string) bool {
+	included := false
+	for _, p := range l {
+		switch {
+		case p.exclude && p.match(dir, true):
+			included = false
+		case !p.exclude && p.canContain(dir):
+			included = true
+		}
+	}
+	return included
+}
+
+// bases returns the directories that hold every path included by the
+// pattern list.
+func (l patternList) bases() []string {
+	var bases []string
+	for _, p := range l {
+		if !p.exclude && filepath.IsAbs(p.glob) {
+			bases = append(bases, p.base())
+		}
+	}
+	return bases
+}
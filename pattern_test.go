@@ -0,0 +1,68 @@
+package gowatch
+
+import "testing"
+
+func TestPatternListMatch(t *testing.T) {
+	tt := []struct {
+		name    string
+		inc     []string
+		exc     []string
+		file    string
+		dir     bool
+		include bool
+	}{
+		{"glob", []string{"**/*.go"}, nil, "/root/a/b.go", false, true},
+		{"no match", []string{"**/*.go"}, nil, "/root/a/b.js", false, false},
+		{"file in included dir", []string{"src"}, nil, "/root/src/a.js", false, true},
+		{"dir only", []string{"src/"}, nil, "/root/src", true, true},
+		{"dir only file", []string{"src/"}, nil, "/root/src", false, false},
+
+		{"excluded", []string{"*"}, []string{"foo"}, "/root/foo", false, false},
+		{"excluded dir", []string{"**/*.go"}, []string{"foo"}, "/root/foo/a.go", false, false},
+		{"exclude prefix", []string{"*"}, []string{"foo"}, "/root/foobar.go", false, true},
+		{"exclude dir only", []string{"*"}, []string{"foo/"}, "/root/foo", false, true},
+
+		{"negated include", []string{"**/*.go", "!vendor/"}, nil, "/root/vendor/a/b.go", false, false},
+		{"re-included", []string{"**/*.go", "!vendor/", "vendor/mine/**/*.go"}, nil, "/root/vendor/mine/a.go", false, true},
+		{"negated exclude", []string{"**/*.go"}, []string{"vendor/", "!vendor/mine/"}, "/root/vendor/mine/a.go", false, true},
+		{"exclude after include", []string{"**/*.go"}, []string{"vendor/", "!vendor/mine/"}, "/root/vendor/a.go", false, false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			l := compilePatterns("/root", tc.inc, tc.exc)
+			if include := l.match(tc.file, tc.dir); include != tc.include {
+				t.Errorf("expected match to be %v, got %v", tc.include, include)
+			}
+		})
+	}
+}
+
+func TestPatternListCouldInclude(t *testing.T) {
+	tt := []struct {
+		name  string
+		inc   []string
+		exc   []string
+		dir   string
+		watch bool
+	}{
+		{"root", []string{"src/**/*.go"}, nil, "/root", true},
+		{"base", []string{"src/**/*.go"}, nil, "/root/src", true},
+		{"below base", []string{"src/**/*.go"}, nil, "/root/src/a/b", true},
+		{"outside base", []string{"src/**/*.go"}, nil, "/root/docs", false},
+		{"single level", []string{"*/*.go"}, nil, "/root/a", true},
+		{"below single level", []string{"*/*.go"}, nil, "/root/a/b", false},
+		{"included dir", []string{"src"}, nil, "/root/src", true},
+		{"excluded", []string{"**/*.go"}, []string{"vendor/"}, "/root/vendor/a", false},
+		{"included again", []string{"**/*.go", "!vendor/", "vendor/mine/*.go"}, nil, "/root/vendor/mine", true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			l := compilePatterns("/root", tc.inc, tc.exc)
+			if watch := l.couldInclude(tc.dir); watch != tc.watch {
+				t.Errorf("expected couldInclude to be %v, got %v", tc.watch, watch)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package gowatch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// A fileState is what pollBackend compares to find changed files.
+type fileState struct {
+	mode    os.FileMode
+	size    int64
+	modTime time.Time
+	inode   uint64
+}
+
+func stateOf(fi os.FileInfo) fileState {
+	return fileState{
+		mode:    fi.Mode(),
+		size:    fi.Size(),
+		modTime: fi.ModTime(),
+		inode:   fileInode(fi),
+	}
+}
+
+// pollBackend is a Backend that lists the watched directories at an
+// interval and compares the state of their files with the previous listing.
+type pollBackend struct {
+	interval time.Duration
+	events   chan fsnotify.Event
+	errors   chan error
+	done     chan struct{}
+	close    sync.Once
+
+	lock sync.Mutex
+	dirs map[string]*polledDir
+}
+
+// A polledDir holds the files of a watched directory as of its last listing.
+type polledDir struct {
+	files map[string]fileState
+}
+
+func newPollBackend(interval time.Duration) *pollBackend {
+	b := &pollBackend{
+		interval: interval,
+		events:   make(chan fsnotify.Event),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+		dirs:     make(map[string]*polledDir),
+	}
+	go b.poll()
+	return b
+}
+
+func (b *pollBackend) Add(dir string) error {
+	files, err := listDir(dir)
+	if err != nil {
+		return err
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := b.dirs[dir]; !ok {
+		b.dirs[dir] = &polledDir{files: files}
+	}
+	return nil
+}
+
+func (b *pollBackend) Remove(dir string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.dirs, dir)
+	return nil
+}
+
+func (b *pollBackend) Events() <-chan fsnotify.Event { return b.events }
+func (b *pollBackend) Errors() <-chan error          { return b.errors }
+
+func (b *pollBackend) Close() error {
+	b.close.Do(func() { close(b.done) })
+	return nil
+}
+
+func (b *pollBackend) poll() {
+	t := time.NewTicker(b.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-b.done:
+			return
+		}
+
+		for _, ev := range b.scan() {
+			select {
+			case b.events <- ev:
+			case <-b.done:
+				return
+			}
+		}
+	}
+}
+
+// scan lists every watched directory and returns the events for the
+// differences to the previous listing. Like inotify, a directory that was
+// removed reports a remove event for itself and stops being watched.
+//
+// Only one event is reported for each changed file: a file that was
+// replaced is reported as created, and a file whose size or modification
+// time changed is reported as written, even if its mode changed as well.
+//
+// Directories are listed without holding the lock, so Add and Remove aren't
+// held up by a slow scan. Directories that were removed or added again in
+// the meantime are left alone until the next scan.
+func (b *pollBackend) scan() []fsnotify.Event {
+	b.lock.Lock()
+	watched := make(map[string]*polledDir, len(b.dirs))
+	for dir, pd := range b.dirs {
+		watched[dir] = pd
+	}
+	b.lock.Unlock()
+
+	type listing struct {
+		files map[string]fileState
+		err   error
+	}
+	listings := make(map[string]listing, len(watched))
+	for dir := range watched {
+		files, err := listDir(dir)
+		listings[dir] = listing{files, err}
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var events []fsnotify.Event
+	for dir, pd := range watched {
+		if b.dirs[dir] != pd {
+			continue
+		}
+
+		l := listings[dir]
+		if l.err != nil {
+			delete(b.dirs, dir)
+			events = append(events, fsnotify.Event{Name: dir, Op: fsnotify.Remove})
+			continue
+		}
+
+		prev, files := pd.files, l.files
+		pd.files = files
+
+		for name, st := range files {
+			file := filepath.Join(dir, name)
+
+			old, ok := prev[name]
+			switch {
+			case !ok || old.inode != st.inode:
+				// A file replaced by another one, as editors do when
+				// saving, has a new inode.
+				events = append(events, fsnotify.Event{Name: file, Op: fsnotify.Create})
+			case old.size != st.size || !old.modTime.Equal(st.modTime):
+				events = append(events, fsnotify.Event{Name: file, Op: fsnotify.Write})
+			case old.mode != st.mode:
+				events = append(events, fsnotify.Event{Name: file, Op: fsnotify.Chmod})
+			}
+		}
+
+		for name := range prev {
+			if _, ok := files[name]; !ok {
+				events = append(events, fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Remove})
+			}
+		}
+	}
+
+	return events
+}
+
+// listDir returns the state of every file in dir by name.
+func listDir(dir string) (map[string]fileState, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]fileState, len(fis))
+	for _, fi := range fis {
+		files[fi.Name()] = stateOf(fi)
+	}
+	return files, nil
+}
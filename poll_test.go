@@ -0,0 +1,81 @@
+package gowatch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestPollBackendScan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gowatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	if err := ioutil.WriteFile(a, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newPollBackend(time.Hour)
+	defer p.Close()
+	if err := p.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(events ...fsnotify.Event) {
+		t.Helper()
+		if actual := p.scan(); !reflect.DeepEqual(actual, events) {
+			t.Errorf("expected events %v, got %v", events, actual)
+		}
+	}
+
+	expect()
+
+	if err := ioutil.WriteFile(b, []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expect(fsnotify.Event{Name: b, Op: fsnotify.Create})
+
+	if err := ioutil.WriteFile(a, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expect(fsnotify.Event{Name: a, Op: fsnotify.Write})
+
+	if err := os.Chmod(a, 0600); err != nil {
+		t.Fatal(err)
+	}
+	expect(fsnotify.Event{Name: a, Op: fsnotify.Chmod})
+
+	// A file whose content and mode both changed is only reported as
+	// written.
+	if err := ioutil.WriteFile(a, []byte("changed again"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(a, 0644); err != nil {
+		t.Fatal(err)
+	}
+	expect(fsnotify.Event{Name: a, Op: fsnotify.Write})
+
+	// Replacing a file gives it a new inode.
+	if err := os.Rename(b, a); err != nil {
+		t.Fatal(err)
+	}
+	expect(
+		fsnotify.Event{Name: a, Op: fsnotify.Create},
+		fsnotify.Event{Name: b, Op: fsnotify.Remove},
+	)
+
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	expect(fsnotify.Event{Name: dir, Op: fsnotify.Remove})
+	expect()
+}
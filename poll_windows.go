@@ -0,0 +1,9 @@
+package gowatch
+
+import "os"
+
+// fileInode returns 0, since files on Windows don't have inode numbers that
+// can be read through os.FileInfo.
+func fileInode(fi os.FileInfo) uint64 {
+	return 0
+}
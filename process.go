@@ -0,0 +1,273 @@
+package gowatch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"mvdan.cc/sh/expand"
+	"mvdan.cc/sh/interp"
+)
+
+const defaultStopTimeout = 5 * time.Second
+
+var stopSignals = map[string]syscall.Signal{
+	"SIGTERM": syscall.SIGTERM,
+	"SIGINT":  syscall.SIGINT,
+	"SIGHUP":  syscall.SIGHUP,
+}
+
+// parseSignal returns the signal with the given name. The SIG prefix is
+// optional. An empty name returns SIGTERM.
+func parseSignal(name string) (syscall.Signal, error) {
+	if name == "" {
+		return syscall.SIGTERM, nil
+	}
+
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	sig, ok := stopSignals[name]
+	if !ok {
+		return 0, fmt.Errorf("unsupported signal %s; expected SIGTERM, SIGINT, or SIGHUP", name)
+	}
+	return sig, nil
+}
+
+// groupExec returns an interpreter module that runs every program in its own
+// process group. When the context of the interpreter is cancelled, sig is
+// sent to the whole group, so that processes started by the program are
+// stopped along with it, and the group is killed if the program hasn't
+// exited after timeout. Processes the program left running in the background
+// are killed as soon as it exits, whether or not it was cancelled.
+func groupExec(sig syscall.Signal, timeout time.Duration) interp.ModuleExec {
+	if timeout <= 0 {
+		timeout = defaultStopTimeout
+	}
+
+	return func(ctx context.Context, path string, args []string) error {
+		mc, _ := interp.FromModuleContext(ctx)
+		if path == "" {
+			fmt.Fprintf(mc.Stderr, "%q: executable file not found in $PATH\n", args[0])
+			return interp.ExitStatus(127)
+		}
+
+		out, err := newOutputPipes(mc.Stdout, mc.Stderr)
+		if err != nil {
+			return err
+		}
+		defer out.wait()
+
+		cmd := exec.Cmd{
+			Path:   path,
+			Args:   args,
+			Env:    environList(mc.Env),
+			Dir:    mc.Dir,
+			Stdin:  mc.Stdin,
+			Stdout: out.stdout,
+			Stderr: out.stderr,
+		}
+		setProcessGroup(&cmd)
+
+		err = cmd.Start()
+		out.started()
+		if err == nil {
+			procs := processSetFrom(ctx)
+			procs.add(cmd.Process)
+			defer procs.remove(cmd.Process)
+
+			exited := make(chan struct{})
+
+			go func() {
+				select {
+				case <-exited:
+					return
+				case <-ctx.Done():
+				}
+
+				signalProcessGroup(cmd.Process, sig)
+
+				select {
+				case <-exited:
+				case <-time.After(timeout):
+				}
+				signalProcessGroup(cmd.Process, syscall.SIGKILL)
+			}()
+
+			err = cmd.Wait()
+			close(exited)
+
+			// Kill whatever the program left behind in its group.
+			signalProcessGroup(cmd.Process, syscall.SIGKILL)
+		}
+
+		switch x := err.(type) {
+		case *exec.ExitError:
+			if status, ok := x.Sys().(syscall.WaitStatus); ok {
+				if status.Signaled() && ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return interp.ExitStatus(status.ExitStatus())
+			}
+			return interp.ExitStatus(1)
+		case *exec.Error:
+			fmt.Fprintf(mc.Stderr, "%v\n", err)
+			return interp.ExitStatus(127)
+		default:
+			return err
+		}
+	}
+}
+
+// outputPipes connects the output of a program to the writers of the
+// interpreter. os/exec copies output to writers that aren't files itself,
+// but then waits for every process holding the other end of the pipe, which
+// includes the background processes of the program. Using our own pipes,
+// waiting for the program returns once it exits, so its background processes
+// can be killed before the copying is waited for.
+type outputPipes struct {
+	stdout, stderr *os.File
+
+	// The ends of the pipes only the program should hold, and the copies
+	// to the writers of the interpreter.
+	child  []*os.File
+	copies sync.WaitGroup
+}
+
+func newOutputPipes(stdout, stderr io.Writer) (*outputPipes, error) {
+	p := &outputPipes{}
+
+	var err error
+	if p.stdout, err = p.pipe(stdout); err != nil {
+		return nil, err
+	}
+
+	// Writing to a shared writer from two copies would mix up its lines.
+	if stderr == stdout {
+		p.stderr = p.stdout
+	} else if p.stderr, err = p.pipe(stderr); err != nil {
+		p.started()
+		p.wait()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// pipe returns the file a program should write to for its output to go to
+// w.
+func (p *outputPipes) pipe(w io.Writer) (*os.File, error) {
+	if w == nil {
+		return nil, nil
+	} else if f, ok := w.(*os.File); ok {
+		return f, nil
+	}
+
+	r, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	p.child = append(p.child, pw)
+
+	p.copies.Add(1)
+	go func() {
+		defer p.copies.Done()
+		defer r.Close()
+		io.Copy(w, r)
+	}()
+
+	return pw, nil
+}
+
+// started closes the ends of the pipes passed to the program once it has
+// started, so the copies end once the program and its children exit.
+func (p *outputPipes) started() {
+	for _, f := range p.child {
+		f.Close()
+	}
+	p.child = nil
+}
+
+// wait waits for all output to be copied.
+func (p *outputPipes) wait() {
+	p.copies.Wait()
+}
+
+// A processSet tracks the running process groups started by groupExec so
+// they can be signalled. A nil processSet ignores all calls.
+type processSet struct {
+	lock  sync.Mutex
+	procs map[*os.Process]bool
+}
+
+type processSetKey struct{}
+
+// withProcessSet returns a context that makes groupExec track the processes
+// it starts in ps.
+func withProcessSet(ctx context.Context, ps *processSet) context.Context {
+	return context.WithValue(ctx, processSetKey{}, ps)
+}
+
+func processSetFrom(ctx context.Context) *processSet {
+	ps, _ := ctx.Value(processSetKey{}).(*processSet)
+	return ps
+}
+
+func (ps *processSet) add(p *os.Process) {
+	if ps == nil {
+		return
+	}
+
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	if ps.procs == nil {
+		ps.procs = make(map[*os.Process]bool)
+	}
+	ps.procs[p] = true
+}
+
+func (ps *processSet) remove(p *os.Process) {
+	if ps == nil {
+		return
+	}
+
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	delete(ps.procs, p)
+}
+
+// Signal sends sig to every tracked process group.
+func (ps *processSet) Signal(sig syscall.Signal) {
+	if ps == nil {
+		return
+	}
+
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	for p := range ps.procs {
+		signalProcessGroup(p, sig)
+	}
+}
+
+// environList returns the exported variables of env in the form used by
+// os/exec.
+func environList(env expand.Environ) []string {
+	var list []string
+	env.Each(func(name string, vr expand.Variable) bool {
+		if vr.Exported {
+			list = append(list, name+"="+vr.String())
+		}
+		return true
+	})
+	return list
+}
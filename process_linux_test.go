@@ -0,0 +1,156 @@
+// +build linux
+
+package gowatch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"mvdan.cc/sh/syntax"
+)
+
+func TestServiceStopKillsProcessGroup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gowatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pidFile := filepath.Join(dir, "pid")
+
+	// The shell ignores the stop signal and leaves a grandchild behind,
+	// so both must be killed once the stop timeout passes.
+	script := `sh -c 'trap "" TERM; echo $$ > ` + pidFile + `; sleep 30 & sleep 30'`
+	f, err := syntax.NewParser().Parse(strings.NewReader(script), "svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &service{
+		Name:        "svc",
+		Dir:         dir,
+		File:        f,
+		StopSignal:  syscall.SIGTERM,
+		StopTimeout: 100 * time.Millisecond,
+	}
+	go s.Run(context.Background(), nil, ioutil.Discard, ioutil.Discard)
+
+	var pid int
+	for start := time.Now(); pid == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("service did not start")
+		}
+
+		b, _ := ioutil.ReadFile(pidFile)
+		pid, _ = strconv.Atoi(strings.TrimSpace(string(b)))
+	}
+
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if procs := liveProcessGroup(t, pid); len(procs) > 0 {
+		t.Errorf("expected process group %d to be gone after Stop, found %v", pid, procs)
+	}
+	if state := s.State(); state != ServiceStopped {
+		t.Errorf("expected service to be %s, got %s", ServiceStopped, state)
+	}
+}
+
+func TestActionKillsBackgroundProcesses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gowatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pidFile := filepath.Join(dir, "pid")
+
+	// The background sleep keeps the output of the action open, which
+	// must not keep the action from finishing.
+	script := `sh -c 'sleep 30 & echo $! > ` + pidFile + `'`
+	f, err := syntax.NewParser().Parse(strings.NewReader(script), "action")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	a := &action{Dir: dir, File: f}
+
+	// Actions only run their programs in a process group when their
+	// processes are tracked, as for on_busy: signal.
+	ctx := withProcessSet(context.Background(), &processSet{})
+
+	done := make(chan error, 1)
+	go func() { done <- a.Run(ctx, nil, &out, &out) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("action did not finish while its background process was running")
+	}
+
+	b, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+
+	// Killed processes take a moment to exit and may linger as zombies
+	// until they are reaped.
+	for start := time.Now(); processAlive(pid); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("expected background process %d to be killed", pid)
+		}
+	}
+}
+
+// processAlive returns whether the process with the given pid exists and
+// isn't a zombie.
+func processAlive(pid int) bool {
+	stat, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+// liveProcessGroup returns the processes in a process group that haven't
+// exited. Zombies are ignored, since they may not be reaped right away.
+func liveProcessGroup(t *testing.T, pgid int) []string {
+	t.Helper()
+
+	stats, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var procs []string
+	for _, stat := range stats {
+		b, err := ioutil.ReadFile(stat)
+		if err != nil {
+			continue
+		}
+
+		// The fields after the command name, which is wrapped in
+		// parentheses, start with the state and the parent, group, and
+		// session IDs.
+		fields := strings.Fields(string(b[strings.LastIndex(string(b), ")")+1:]))
+		if len(fields) < 3 || fields[0] == "Z" || fields[2] != strconv.Itoa(pgid) {
+			continue
+		}
+		procs = append(procs, filepath.Base(filepath.Dir(stat)))
+	}
+	return procs
+}
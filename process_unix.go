@@ -0,0 +1,19 @@
+// +build !windows
+
+package gowatch
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes cmd start in a new process group.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalProcessGroup sends sig to the process group led by p.
+func signalProcessGroup(p *os.Process, sig syscall.Signal) {
+	syscall.Kill(-p.Pid, sig)
+}
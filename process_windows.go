@@ -0,0 +1,18 @@
+// +build windows
+
+package gowatch
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Process groups aren't supported on Windows.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalProcessGroup kills p. Windows can't deliver other signals, so the
+// process is killed regardless of sig.
+func signalProcessGroup(p *os.Process, sig syscall.Signal) {
+	p.Kill()
+}
@@ -0,0 +1,238 @@
+package gowatch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"mvdan.cc/sh/expand"
+	"mvdan.cc/sh/interp"
+	"mvdan.cc/sh/syntax"
+)
+
+const (
+	defaultReadyTimeout  = 30 * time.Second
+	defaultReadyInterval = 250 * time.Millisecond
+)
+
+// A ReadinessProbe checks whether a service has finished starting up. Exactly
+// one of TCP, HTTP, Log, or Script must be set.
+type ReadinessProbe struct {
+	// TCP holds a host:port address. The service is ready once a connection
+	// to the address can be opened.
+	TCP string `yaml:"tcp"`
+
+	// HTTP holds a URL. The service is ready once a GET request to the URL
+	// returns a 2xx status code.
+	HTTP string `yaml:"http"`
+
+	// Log holds a regular expression. The service is ready once a line
+	// written to its stdout or stderr matches the expression.
+	Log string `yaml:"log"`
+
+	// Script holds a script that is ran repeatedly. The service is ready
+	// once the script exits successfully.
+	Script string `yaml:"script"`
+
+	// Timeout is how long to wait for the service to become ready before
+	// failing. Defaults to 30s.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Interval is how long to wait between checks. Defaults to 250ms. Has
+	// no effect on log probes.
+	Interval time.Duration `yaml:"interval"`
+}
+
+func (p *ReadinessProbe) validate() error {
+	set := 0
+	for _, v := range []string{p.TCP, p.HTTP, p.Log, p.Script} {
+		if v != "" {
+			set++
+		}
+	}
+
+	if set != 1 {
+		return fmt.Errorf("exactly one of tcp, http, log, or script must be set")
+	}
+
+	if p.Log != "" {
+		if _, err := regexp.Compile(p.Log); err != nil {
+			return fmt.Errorf("invalid log pattern: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// readinessCheck is a compiled ReadinessProbe.
+type readinessCheck struct {
+	probe   ReadinessProbe
+	pattern *regexp.Regexp
+	script  *syntax.File
+}
+
+func compileReadinessProbe(name string, p *ReadinessProbe) (*readinessCheck, error) {
+	if p == nil {
+		return nil, nil
+	}
+
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+
+	c := &readinessCheck{probe: *p}
+	if c.probe.Timeout <= 0 {
+		c.probe.Timeout = defaultReadyTimeout
+	}
+	if c.probe.Interval <= 0 {
+		c.probe.Interval = defaultReadyInterval
+	}
+
+	if p.Log != "" {
+		c.pattern = regexp.MustCompile(p.Log)
+	}
+
+	if p.Script != "" {
+		f, err := syntax.NewParser().Parse(strings.NewReader(p.Script), name)
+		if err != nil {
+			return nil, err
+		}
+		c.script = f
+	}
+
+	return c, nil
+}
+
+// errExitedBeforeReady is returned by Wait when the service exits before
+// the probe passes.
+var errExitedBeforeReady = errors.New("exited before becoming ready")
+
+// Wait blocks until the probe passes, the probe times out, the service
+// exits, or ctx is cancelled. logs must be closed once the service logs a
+// line matching the probe's pattern, and exited once the service exits.
+func (c *readinessCheck) Wait(ctx context.Context, dir string, env []string, logs <-chan struct{}, exited <-chan struct{}) error {
+	ctx, cancel := context.WithTimeout(ctx, c.probe.Timeout)
+	defer cancel()
+
+	err := c.wait(ctx, dir, env, logs, exited)
+	if err == context.DeadlineExceeded {
+		return fmt.Errorf("not ready after %s", c.probe.Timeout)
+	}
+	return err
+}
+
+func (c *readinessCheck) wait(ctx context.Context, dir string, env []string, logs <-chan struct{}, exited <-chan struct{}) error {
+	if c.pattern != nil {
+		select {
+		case <-logs:
+			return nil
+		case <-exited:
+			return errExitedBeforeReady
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for {
+		if c.check(ctx, dir, env) {
+			return nil
+		}
+
+		select {
+		case <-time.After(c.probe.Interval):
+		case <-exited:
+			return errExitedBeforeReady
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// check runs the probe once and returns whether it passed.
+func (c *readinessCheck) check(ctx context.Context, dir string, env []string) bool {
+	switch {
+	case c.probe.TCP != "":
+		d := net.Dialer{Timeout: c.probe.Interval}
+		conn, err := d.DialContext(ctx, "tcp", c.probe.TCP)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+
+	case c.probe.HTTP != "":
+		req, err := http.NewRequest(http.MethodGet, c.probe.HTTP, nil)
+		if err != nil {
+			return false
+		}
+
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode >= 200 && resp.StatusCode < 300
+
+	case c.script != nil:
+		runner, err := interp.New(
+			interp.Dir(dir),
+			interp.Env(expand.ListEnviron(mergeEnv(os.Environ(), env)...)),
+			interp.StdIO(nil, nil, nil),
+		)
+		if err != nil {
+			return false
+		}
+		return runner.Run(ctx, c.script) == nil
+	}
+
+	return false
+}
+
+// lineMatcher is an io.Writer that passes everything through to an
+// underlying writer and closes Matched once a written line matches a
+// pattern.
+type lineMatcher struct {
+	w       io.Writer
+	pattern *regexp.Regexp
+	matched chan struct{}
+	once    *sync.Once
+
+	buf []byte
+}
+
+// newLineMatchers returns a pair of lineMatchers wrapping stdout and stderr
+// that share the same Matched channel.
+func newLineMatchers(pattern *regexp.Regexp, stdout, stderr io.Writer) (io.Writer, io.Writer, <-chan struct{}) {
+	matched := make(chan struct{})
+	once := &sync.Once{}
+
+	mout := &lineMatcher{w: stdout, pattern: pattern, matched: matched, once: once}
+	merr := &lineMatcher{w: stderr, pattern: pattern, matched: matched, once: once}
+	return mout, merr, matched
+}
+
+func (m *lineMatcher) Write(p []byte) (n int, err error) {
+	m.buf = append(m.buf, p...)
+	for {
+		idx := bytes.IndexByte(m.buf, '\n')
+		if idx < 0 {
+			break
+		}
+
+		if m.pattern.Match(m.buf[:idx]) {
+			m.once.Do(func() { close(m.matched) })
+		}
+		m.buf = m.buf[idx+1:]
+	}
+
+	return m.w.Write(p)
+}
@@ -0,0 +1,199 @@
+package gowatch
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mustCompileProbe compiles a readiness probe, failing the test on error.
+func mustCompileProbe(t *testing.T, p ReadinessProbe) *readinessCheck {
+	t.Helper()
+
+	c, err := compileReadinessProbe("probe", &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestReadinessTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+
+	c := mustCompileProbe(t, ReadinessProbe{TCP: addr, Interval: 10 * time.Millisecond})
+	if !c.check(context.Background(), "", nil) {
+		t.Errorf("expected probe of listening address to pass")
+	}
+
+	l.Close()
+	if c.check(context.Background(), "", nil) {
+		t.Errorf("expected probe of closed address to fail")
+	}
+}
+
+func TestReadinessTCPCancel(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	c := mustCompileProbe(t, ReadinessProbe{TCP: l.Addr().String(), Interval: time.Hour})
+
+	// The address accepts connections, so the probe only fails if the dial
+	// respects the cancelled context.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if c.check(ctx, "", nil) {
+		t.Errorf("expected probe with a cancelled context to fail")
+	}
+}
+
+func TestReadinessHTTP(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	c := mustCompileProbe(t, ReadinessProbe{HTTP: srv.URL, Interval: 10 * time.Millisecond})
+	if err := c.Wait(context.Background(), "", nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("expected ready after 3 requests, got %d", n)
+	}
+}
+
+func TestReadinessScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gowatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := mustCompileProbe(t, ReadinessProbe{Script: `test -f "$READY_FILE"`})
+	env := []string{"READY_FILE=" + filepath.Join(dir, "ready")}
+
+	if c.check(context.Background(), dir, env) {
+		t.Errorf("expected script probe to fail before the file exists")
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "ready"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !c.check(context.Background(), dir, env) {
+		t.Errorf("expected script probe to pass once the file exists")
+	}
+}
+
+func TestReadinessTimeout(t *testing.T) {
+	c := mustCompileProbe(t, ReadinessProbe{Script: "false", Interval: 10 * time.Millisecond, Timeout: 50 * time.Millisecond})
+
+	err := c.Wait(context.Background(), "", nil, nil, nil)
+	if err == nil || err.Error() != "not ready after 50ms" {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+}
+
+func TestReadinessExited(t *testing.T) {
+	tt := map[string]ReadinessProbe{
+		"script": {Script: "false", Interval: 10 * time.Millisecond},
+		"log":    {Log: "ready"},
+	}
+
+	for name, p := range tt {
+		t.Run(name, func(t *testing.T) {
+			c := mustCompileProbe(t, p)
+
+			exited := make(chan struct{})
+			close(exited)
+
+			if err := c.Wait(context.Background(), "", nil, make(chan struct{}), exited); err != errExitedBeforeReady {
+				t.Errorf("expected %v, got %v", errExitedBeforeReady, err)
+			}
+		})
+	}
+}
+
+func TestServiceExitsBeforeReady(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gowatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w := NewWatcher(dir, Config{
+		Services: map[string]Service{
+			"web": {
+				Script:  Script{Run: "exit 1"},
+				Restart: RestartAlways,
+				Backoff: Backoff{Initial: time.Hour},
+				Ready:   &ReadinessProbe{Script: "false", Timeout: time.Hour},
+			},
+		},
+	})
+	w.services, err = w.compileServices(w.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.stopServices()
+
+	done := make(chan error, 1)
+	go func() { done <- w.Run(context.Background(), "web") }()
+
+	select {
+	case err := <-done:
+		if err != errExitedBeforeReady {
+			t.Errorf("expected %v, got %v", errExitedBeforeReady, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("still waiting for readiness after the service exited")
+	}
+}
+
+func TestLineMatcher(t *testing.T) {
+	var out, errOut bytes.Buffer
+	stdout, stderr, matched := newLineMatchers(regexp.MustCompile(`^listening on \d+$`), &out, &errOut)
+
+	// Lines are only matched once they are complete, and may be split
+	// across writes.
+	stdout.Write([]byte("starting\nlistening on 80"))
+	stderr.Write([]byte("listening on 8080 "))
+	select {
+	case <-matched:
+		t.Fatal("matched an incomplete or non-matching line")
+	default:
+	}
+
+	stdout.Write([]byte("80\n"))
+	select {
+	case <-matched:
+	default:
+		t.Fatal("expected a match once the line was completed")
+	}
+
+	// Output is passed through unchanged, and later matches don't close
+	// the channel again.
+	stderr.Write([]byte("\nlistening on 1\n"))
+	if out.String() != "starting\nlistening on 8080\n" {
+		t.Errorf("unexpected stdout %q", out.String())
+	}
+	if errOut.String() != "listening on 8080 \nlistening on 1\n" {
+		t.Errorf("unexpected stderr %q", errOut.String())
+	}
+}
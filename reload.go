@@ -0,0 +1,100 @@
+package gowatch
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Reload replaces the config of a running watcher. The new config is
+// validated first; if it is invalid, an error is returned and the watcher
+// keeps running with its previous config.
+//
+// Actions are recompiled and file triggers take effect for the next batch
+// of file changes. Services that were removed are stopped, and running
+// services whose definition or environment changed are restarted, including
+// when only the contents of an env file changed. Services that did not
+// change keep running untouched. Startup steps are not run again.
+func (w *Watcher) Reload(cfg Config) error {
+	candidate := &Watcher{Directory: w.Directory, Config: cfg}
+	if err := candidate.Validate(); err != nil {
+		return err
+	}
+
+	actions, err := w.compileActions(cfg)
+	if err != nil {
+		return err
+	}
+
+	services, err := w.compileServices(cfg)
+	if err != nil {
+		return err
+	}
+
+	cfg.FileTriggers = w.compileFileTriggers(cfg)
+
+	w.lock.Lock()
+
+	var (
+		prev      = w.Config
+		stopped   []*service
+		restarted []string
+	)
+
+	for name, old := range w.services {
+		def, ok := cfg.Services[name]
+		if !ok {
+			stopped = append(stopped, old)
+			continue
+		}
+
+		if reflect.DeepEqual(prev.Services[name], def) && !w.envChanged(old, cfg, def) {
+			// Keep the running instance of unchanged services.
+			services[name] = old
+			continue
+		}
+
+		switch old.State() {
+		case ServiceRunning, ServiceRestarting, ServiceCrashLoop:
+			restarted = append(restarted, name)
+		}
+		stopped = append(stopped, old)
+	}
+
+	w.Config = cfg
+	w.actions = actions
+	w.services = services
+	rescan := w.rescan
+	w.lock.Unlock()
+
+	for _, s := range stopped {
+		s.Stop()
+	}
+
+	sort.Strings(restarted)
+	for _, name := range restarted {
+		fmt.Fprintf(w.Debug, "[%s] DEFINITION CHANGED; restarting\n", name)
+		go w.runStep(w.context(), name, nil)
+	}
+
+	// Have the watch set rebuilt for the new file triggers right away.
+	select {
+	case rescan <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// envChanged returns whether the variables set by the env files and env
+// settings of def in cfg differ from the ones s was last started with.
+// Services that were never started haven't got an environment to compare.
+func (w *Watcher) envChanged(s *service, cfg Config, def Service) bool {
+	started := s.startedEnv()
+	if started == nil {
+		return false
+	}
+
+	env, err := w.scriptEnv(cfg, def.Script)
+	return err != nil || !reflect.DeepEqual(env, started)
+}
@@ -0,0 +1,180 @@
+package gowatch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	w := NewWatcher(dir, Config{
+		Actions: map[string]Script{"vet": {Run: "go vet"}},
+	})
+
+	err := w.Reload(Config{
+		Actions: map[string]Script{"test": {Run: "go test"}},
+		FileTriggers: []FileTrigger{
+			{Include: []string{"*.go"}, Triggers: []Step{{Trigger: "missing"}}},
+		},
+	})
+	if err == nil {
+		t.Fatal("expected invalid config to be rejected")
+	}
+	if actions := w.Actions(); len(actions) != 1 || actions[0].Name != "vet" {
+		t.Fatalf("expected previous config to be kept, got actions %v", actions)
+	}
+
+	err = w.Reload(Config{
+		Actions: map[string]Script{"test": {Run: "go test"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actions := w.Actions(); len(actions) != 1 || actions[0].Name != "test" {
+		t.Fatalf("expected new config to be used, got actions %v", actions)
+	}
+}
+
+// reloadConfig returns a config running the given services on start.
+func reloadConfig(services map[string]Service) Config {
+	cfg := Config{
+		Actions:  map[string]Script{"noop": {Run: "true"}},
+		Services: services,
+		FileTriggers: []FileTrigger{
+			{Include: []string{"*.go"}, Triggers: []Step{{Trigger: "noop"}}},
+		},
+	}
+	for name := range services {
+		cfg.StartupSteps = append(cfg.StartupSteps, name)
+	}
+	sort.Strings(cfg.StartupSteps)
+	return cfg
+}
+
+// serviceEvents collects the service events published until timeout passes
+// without any new ones, keyed by service name.
+func serviceEvents(ch <-chan Event, timeout time.Duration) map[string][]EventType {
+	events := make(map[string][]EventType)
+	for {
+		select {
+		case ev := <-ch:
+			switch ev.Type {
+			case EventServiceStarted, EventServiceStopped:
+				events[ev.Trigger] = append(events[ev.Trigger], ev.Type)
+			}
+		case <-time.After(timeout):
+			return events
+		}
+	}
+}
+
+func TestReloadServices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gowatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w := NewWatcher(dir, reloadConfig(map[string]Service{
+		"same":    {Script: Script{Run: "sleep 30"}},
+		"changed": {Script: Script{Run: "sleep 30"}},
+		"removed": {Script: Script{Run: "sleep 30"}},
+	}))
+
+	events := w.Subscribe()
+	defer w.Unsubscribe(events)
+
+	_, stop := startWatcher(t, w)
+	defer stop()
+
+	started := serviceEvents(events, 300*time.Millisecond)
+	for _, name := range []string{"same", "changed", "removed"} {
+		if !reflect.DeepEqual(started[name], []EventType{EventServiceStarted}) {
+			t.Fatalf("expected %s to be started once, got %v", name, started[name])
+		}
+	}
+
+	err = w.Reload(reloadConfig(map[string]Service{
+		"same":    {Script: Script{Run: "sleep 30"}},
+		"changed": {Script: Script{Run: "sleep 31"}},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := serviceEvents(events, 300*time.Millisecond)
+	expect := map[string][]EventType{
+		"changed": {EventServiceStopped, EventServiceStarted},
+		"removed": {EventServiceStopped},
+	}
+	if !reflect.DeepEqual(reloaded, expect) {
+		t.Errorf("expected service events %v after reload, got %v", expect, reloaded)
+	}
+
+	for name, state := range map[string]ServiceState{"same": ServiceRunning, "changed": ServiceRunning} {
+		if got, err := w.ServiceState(name); err != nil || got != state {
+			t.Errorf("expected %s to be %s, got %s (%v)", name, state, got, err)
+		}
+	}
+	if _, err := w.ServiceState("removed"); err == nil {
+		t.Errorf("expected removed service to be gone")
+	}
+}
+
+func TestReloadEnvFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gowatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	envFile := filepath.Join(dir, ".env")
+	if err := ioutil.WriteFile(envFile, []byte("PORT=8080\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := reloadConfig(map[string]Service{
+		"api":   {Script: Script{Run: "sleep 30", EnvFile: ".env"}},
+		"other": {Script: Script{Run: "sleep 30"}},
+	})
+	w := NewWatcher(dir, cfg)
+
+	events := w.Subscribe()
+	defer w.Unsubscribe(events)
+
+	_, stop := startWatcher(t, w)
+	defer stop()
+	serviceEvents(events, 300*time.Millisecond)
+
+	// Reloading the same config only restarts the service whose env file
+	// changed in the meantime.
+	if err := ioutil.WriteFile(envFile, []byte("PORT=9090\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reload(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := serviceEvents(events, 300*time.Millisecond)
+	expect := map[string][]EventType{
+		"api": {EventServiceStopped, EventServiceStarted},
+	}
+	if !reflect.DeepEqual(reloaded, expect) {
+		t.Errorf("expected service events %v after reload, got %v", expect, reloaded)
+	}
+
+	// Nothing changed since the last reload.
+	if err := w.Reload(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if again := serviceEvents(events, 300*time.Millisecond); len(again) != 0 {
+		t.Errorf("expected no service events after an unchanged reload, got %v", again)
+	}
+}
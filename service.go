@@ -4,82 +4,373 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math/rand"
+	"os"
 	"sync"
+	"syscall"
 	"time"
 
+	"mvdan.cc/sh/expand"
 	"mvdan.cc/sh/interp"
 	"mvdan.cc/sh/syntax"
 )
 
+const (
+	defaultBackoffInitial = 150 * time.Millisecond
+	defaultBackoffMax     = 30 * time.Second
+
+	// crashLoopThreshold is the number of consecutive quick exits after
+	// which a service is considered to be crash looping.
+	crashLoopThreshold = 5
+)
+
+// ServiceState describes what a service is currently doing.
+type ServiceState int
+
+const (
+	// ServiceStopped means the service is not running.
+	ServiceStopped ServiceState = iota
+
+	// ServiceRunning means the service is running.
+	ServiceRunning
+
+	// ServiceRestarting means the service exited and is waiting to be
+	// restarted.
+	ServiceRestarting
+
+	// ServiceCrashLoop means the service keeps failing shortly after being
+	// started and is waiting to be restarted.
+	ServiceCrashLoop
+
+	// ServiceExited means the service exited and will not be restarted
+	// because of its restart policy.
+	ServiceExited
+
+	// ServiceFailed means the service failed and will not be restarted,
+	// either because of its restart policy or because it hit its maximum
+	// number of restarts.
+	ServiceFailed
+)
+
+// MarshalText implements encoding.TextMarshaler.
+func (s ServiceState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *ServiceState) UnmarshalText(text []byte) error {
+	for state := ServiceStopped; state <= ServiceFailed; state++ {
+		if state.String() == string(text) {
+			*s = state
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown service state %q", text)
+}
+
+func (s ServiceState) String() string {
+	switch s {
+	case ServiceStopped:
+		return "stopped"
+	case ServiceRunning:
+		return "running"
+	case ServiceRestarting:
+		return "restarting"
+	case ServiceCrashLoop:
+		return "crash loop"
+	case ServiceExited:
+		return "exited"
+	case ServiceFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("ServiceState(%d)", int(s))
+	}
+}
+
 type service struct {
+	// The name of the service
+	Name string
+
+	// Where to publish events about the service. May be nil.
+	Events *eventHub
+
 	// The directory to run the service in
 	Dir string
 
 	// The bash script to run
 	File *syntax.File
 
-	// The context of the currently running service and the function
-	// to cancel it.
-	ctx  context.Context
-	done context.CancelFunc
+	// A human-readable explanation of the service
+	Description string
+
+	// The readiness check to wait on after starting the service. May be nil.
+	Ready *readinessCheck
+
+	// When to restart the service after it exits
+	Restart RestartPolicy
+
+	// The maximum number of consecutive restarts after failures. Zero
+	// means no limit.
+	MaxRestarts int
+
+	// The delay between restarts
+	Backoff Backoff
 
+	// The signal sent to the processes of the service when it is stopped
+	StopSignal syscall.Signal
+
+	// How long to wait for the processes of the service to exit before
+	// killing them
+	StopTimeout time.Duration
+
+	// cancel stops the currently running service and exited is closed once
+	// it has stopped. Both are guarded by runLock.
+	cancel  context.CancelFunc
+	exited  chan struct{}
+	runLock sync.Mutex
+
+	// lock is held while the service is running.
 	lock sync.Mutex
+
+	// env holds the variables set by the env files and env settings the
+	// service was last started with. It is nil until the service is
+	// started. Both are guarded by stateLock.
+	state     ServiceState
+	env       []string
+	stateLock sync.Mutex
+}
+
+// State returns the current state of the service.
+func (s *service) State() ServiceState {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	return s.state
+}
+
+func (s *service) setState(state ServiceState) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	s.state = state
+}
+
+// startedEnv returns the variables set by the env files and env settings
+// the service was last started with, or nil if it was never started.
+func (s *service) startedEnv() []string {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	return s.env
+}
+
+func (s *service) setStartedEnv(env []string) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	s.env = env
+}
+
+// shouldRestart returns whether the service should be restarted after
+// exiting.
+func (s *service) shouldRestart(failed bool) bool {
+	switch s.Restart {
+	case RestartNever:
+		return false
+	case RestartOnFailure:
+		return failed
+	default:
+		return true
+	}
+}
+
+// delay returns how long to wait before restarting a service that has
+// exited shortly after being started the given number of times in a row.
+func (s *service) delay(failures int) time.Duration {
+	initial, max := s.Backoff.Initial, s.Backoff.Max
+	if initial <= 0 {
+		initial = defaultBackoffInitial
+	}
+	if max <= 0 {
+		max = defaultBackoffMax
+	}
+
+	d := initial
+	for i := 1; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	if s.Backoff.Jitter > 0 {
+		d += time.Duration(rand.Float64() * s.Backoff.Jitter * float64(d))
+	}
+
+	return d
 }
 
 // Run starts the service and keeps it alive. If the service is already
-// running, it will be stopped. This method exits when the provided context
-// is cancelled or the service is stopped using Stop. Since it is likely that
-// the service will be launched in a goroutine, a mutex is used to ensure that
-// we only start the new one once the old one has completely shut down.
-func (s *service) Run(ctx context.Context, stdout, stderr io.Writer) error {
-	if s.done != nil {
-		s.done()
+// running, it will be stopped first. This method exits when the provided
+// context is cancelled, the service is stopped using Stop, or the service
+// exits and its restart policy says not to restart it. Since it is likely
+// that the service will be launched in a goroutine, a mutex is used to
+// ensure that we only start the new one once the old one has completely
+// shut down.
+//
+// env holds extra environment variables to pass to the service on top of
+// the environment of the current process. Restarts and crash loops are
+// reported to stderr.
+func (s *service) Run(ctx context.Context, env []string, stdout, stderr io.Writer) error {
+	return s.run(ctx, env, stdout, stderr, nil)
+}
+
+// run is Run, closing exited, if not nil, as soon as the service exits or
+// fails to start for the first time, even if it is restarted afterwards.
+func (s *service) run(ctx context.Context, env []string, stdout, stderr io.Writer, exited chan<- struct{}) error {
+	notifyExit := func() {
+		if exited != nil {
+			close(exited)
+			exited = nil
+		}
 	}
+	defer notifyExit()
+
+	s.Stop()
 
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	s.ctx, s.done = context.WithCancel(ctx)
-	defer s.done()
+	ctx, cancel := context.WithCancel(ctx)
+	stopped := make(chan struct{})
+
+	s.runLock.Lock()
+	s.cancel, s.exited = cancel, stopped
+	s.runLock.Unlock()
+
+	defer close(stopped)
+	defer cancel()
 
+	// quick counts how many times in a row the service exited shortly after
+	// being started, whether it failed or not, and drives the backoff.
+	// failures counts how many times in a row it failed, for MaxRestarts.
+	// Both are reset once the service ran for as long as the maximum delay.
+	quick, failures := 0, 0
+
+	max := s.Backoff.Max
+	if max <= 0 {
+		max = defaultBackoffMax
+	}
+
+loop:
 	for {
 		runner, err := interp.New(
 			interp.Dir(s.Dir),
+			interp.Env(expand.ListEnviron(mergeEnv(os.Environ(), env)...)),
 			interp.StdIO(nil, stdout, stderr),
+			interp.Module(groupExec(s.StopSignal, s.StopTimeout)),
 		)
 		if err != nil {
+			s.setState(ServiceFailed)
 			return err
 		}
 
+		s.setState(ServiceRunning)
+		s.Events.Publish(Event{Type: EventServiceStarted, Trigger: s.Name})
+		started := time.Now()
+
 		select {
-		case <-s.ctx.Done():
-			break
+		case <-ctx.Done():
+			break loop
 		default:
-			err = runner.Run(s.ctx, s.File)
+			err = runner.Run(ctx, s.File)
 		}
 
-		if err == context.Canceled {
-			break
+		if ctx.Err() != nil {
+			break loop
 		}
+		notifyExit()
+
+		failed := err != nil
 
-		// Something went wrong and the program exited. Wait a little
-		// bit before restarting it.
-		time.Sleep(150 * time.Millisecond)
+		exitEvent := Event{Type: EventServiceExited, Trigger: s.Name, ExitCode: exitCode(err)}
+		if failed {
+			exitEvent.Error = err.Error()
+		}
+		s.Events.Publish(exitEvent)
+
+		if !s.shouldRestart(failed) {
+			if failed {
+				fmt.Fprintf(stderr, "FAILED: %v\n", err)
+				s.setState(ServiceFailed)
+			} else {
+				s.setState(ServiceExited)
+			}
+			time.Sleep(150 * time.Millisecond)
+			return err
+		}
+
+		if time.Since(started) >= max {
+			quick, failures = 0, 0
+		}
+		quick++
+		if failed {
+			failures++
+		} else {
+			failures = 0
+		}
+
+		if s.MaxRestarts > 0 && failures > s.MaxRestarts {
+			fmt.Fprintf(stderr, "FAILED: %v; giving up after %d restarts\n", err, s.MaxRestarts)
+			s.setState(ServiceFailed)
+			time.Sleep(150 * time.Millisecond)
+			return err
+		}
+
+		delay := s.delay(quick)
+
+		switch {
+		case quick >= crashLoopThreshold:
+			if quick == crashLoopThreshold {
+				fmt.Fprintf(stderr, "CRASH LOOP: exited %d times in a row shortly after starting\n", quick)
+			}
+			fmt.Fprintf(stderr, "EXITED: %v; restarting in %s\n", err, delay)
+			s.setState(ServiceCrashLoop)
+		case failed:
+			fmt.Fprintf(stderr, "EXITED: %v; restarting in %s\n", err, delay)
+			s.setState(ServiceRestarting)
+		default:
+			s.setState(ServiceRestarting)
+		}
+
+		s.Events.Publish(Event{Type: EventServiceRestarting, Trigger: s.Name, Delay: delay})
+
+		// Wait a little bit before restarting it.
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			break loop
+		}
 	}
 
+	s.setState(ServiceStopped)
+	s.Events.Publish(Event{Type: EventServiceStopped, Trigger: s.Name})
+
 	// Wait 150ms before returning to let everything clean up
 	time.Sleep(150 * time.Millisecond)
 	return nil
 }
 
-// Stop stops the service. Fails if it is not currently running.
+// Stop stops the service and waits for its processes to exit. Fails if it
+// is not currently running.
 func (s *service) Stop() error {
-	if s.ctx == nil {
+	s.runLock.Lock()
+	cancel, exited := s.cancel, s.exited
+	s.cancel, s.exited = nil, nil
+	s.runLock.Unlock()
+
+	if cancel == nil {
 		return fmt.Errorf("service not started")
 	}
 
-	s.done()
-	s.ctx = nil
-	s.done = nil
+	cancel()
+	<-exited
 	return nil
 }
@@ -0,0 +1,207 @@
+package gowatch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mvdan.cc/sh/syntax"
+)
+
+func TestServiceDelay(t *testing.T) {
+	s := &service{Backoff: Backoff{Initial: 100 * time.Millisecond, Max: time.Second}}
+
+	expect := []time.Duration{
+		100 * time.Millisecond, // the first failure waits for Initial
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second, // capped at Max
+		time.Second,
+	}
+	for i, d := range expect {
+		if got := s.delay(i); got != d {
+			t.Errorf("delay after %d failures: expected %s, got %s", i, d, got)
+		}
+	}
+
+	def := &service{}
+	if got := def.delay(1); got != defaultBackoffInitial {
+		t.Errorf("expected default initial delay %s, got %s", defaultBackoffInitial, got)
+	}
+	if got := def.delay(100); got != defaultBackoffMax {
+		t.Errorf("expected default max delay %s, got %s", defaultBackoffMax, got)
+	}
+}
+
+func TestServiceDelayJitter(t *testing.T) {
+	s := &service{Backoff: Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Jitter: 0.5}}
+
+	for _, failures := range []int{1, 3, 10} {
+		base := (&service{Backoff: Backoff{Initial: s.Backoff.Initial, Max: s.Backoff.Max}}).delay(failures)
+
+		for i := 0; i < 100; i++ {
+			d := s.delay(failures)
+			if d < base || d >= base+base/2 {
+				t.Fatalf("delay after %d failures: expected %s <= delay < %s, got %s", failures, base, base+base/2, d)
+			}
+		}
+	}
+}
+
+func TestServiceShouldRestart(t *testing.T) {
+	tt := []struct {
+		policy           RestartPolicy
+		success, failure bool
+	}{
+		{"", true, true},
+		{RestartAlways, true, true},
+		{RestartOnFailure, false, true},
+		{RestartNever, false, false},
+	}
+
+	for _, tc := range tt {
+		s := &service{Restart: tc.policy}
+		if got := s.shouldRestart(false); got != tc.success {
+			t.Errorf("%q after success: expected %v, got %v", tc.policy, tc.success, got)
+		}
+		if got := s.shouldRestart(true); got != tc.failure {
+			t.Errorf("%q after failure: expected %v, got %v", tc.policy, tc.failure, got)
+		}
+	}
+}
+
+func TestServiceMaxRestarts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gowatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	log := filepath.Join(dir, "log")
+	f, err := syntax.NewParser().Parse(strings.NewReader("echo run >> "+log+"; exit 1"), "svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stderr strings.Builder
+	s := &service{
+		Name:        "svc",
+		Dir:         dir,
+		File:        f,
+		MaxRestarts: 2,
+		Backoff:     Backoff{Initial: time.Millisecond},
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Run(context.Background(), nil, ioutil.Discard, &stderr) }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected the last failure to be returned")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("service was not given up on")
+	}
+
+	b, _ := ioutil.ReadFile(log)
+	if runs := len(strings.Fields(string(b))); runs != 3 {
+		t.Errorf("expected the service to run 3 times, ran %d times", runs)
+	}
+	if state := s.State(); state != ServiceFailed {
+		t.Errorf("expected state %s, got %s", ServiceFailed, state)
+	}
+	if !strings.Contains(stderr.String(), "giving up after 2 restarts") {
+		t.Errorf("expected giving up to be reported, got %q", stderr.String())
+	}
+}
+
+func TestServiceCrashLoop(t *testing.T) {
+	f, err := syntax.NewParser().Parse(strings.NewReader("exit 1"), "svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := &eventHub{}
+	sub := events.Subscribe()
+	defer events.Unsubscribe(sub)
+
+	s := &service{
+		Name:    "svc",
+		Events:  events,
+		Dir:     os.TempDir(),
+		File:    f,
+		Backoff: Backoff{Initial: 10 * time.Millisecond, Max: time.Hour},
+	}
+	go s.Run(context.Background(), nil, ioutil.Discard, ioutil.Discard)
+	defer s.Stop()
+
+	timeout := time.After(5 * time.Second)
+	for restarts := 0; restarts < crashLoopThreshold; {
+		select {
+		case ev := <-sub:
+			if ev.Type == EventServiceRestarting {
+				restarts++
+			}
+		case <-timeout:
+			t.Fatal("service was not restarted")
+		}
+	}
+
+	// The state is set before the restart is published, and the service
+	// waits 160ms before it is restarted again.
+	if state := s.State(); state != ServiceCrashLoop {
+		t.Errorf("expected state %s after %d failures, got %s", ServiceCrashLoop, crashLoopThreshold, state)
+	}
+}
+
+func TestServiceCleanExitBackoff(t *testing.T) {
+	f, err := syntax.NewParser().Parse(strings.NewReader("exit 0"), "svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := &eventHub{}
+	sub := events.Subscribe()
+	defer events.Unsubscribe(sub)
+
+	s := &service{
+		Name:    "svc",
+		Events:  events,
+		Dir:     os.TempDir(),
+		File:    f,
+		Backoff: Backoff{Initial: 10 * time.Millisecond, Max: time.Hour},
+	}
+	go s.Run(context.Background(), nil, ioutil.Discard, ioutil.Discard)
+	defer s.Stop()
+
+	// A service exiting cleanly right away backs off like a failing one
+	// instead of being restarted at the same pace forever.
+	var delays []time.Duration
+	timeout := time.After(5 * time.Second)
+	for len(delays) < crashLoopThreshold {
+		select {
+		case ev := <-sub:
+			if ev.Type == EventServiceRestarting {
+				delays = append(delays, ev.Delay)
+			}
+		case <-timeout:
+			t.Fatal("service was not restarted")
+		}
+	}
+
+	for i := 1; i < len(delays); i++ {
+		if delays[i] != 2*delays[i-1] {
+			t.Fatalf("expected the delay to double on every exit, got %v", delays)
+		}
+	}
+	if state := s.State(); state != ServiceCrashLoop {
+		t.Errorf("expected state %s after %d quick exits, got %s", ServiceCrashLoop, crashLoopThreshold, state)
+	}
+}
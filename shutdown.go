@@ -0,0 +1,57 @@
+package gowatch
+
+import (
+	"context"
+	"sync"
+)
+
+// Shutdown stops a running watcher. The trigger sequence in progress is
+// cancelled, file watching stops, and all services are stopped gracefully.
+// Shutdown waits for Start to return, or until ctx is done, in which case
+// ctx's error is returned. Calling Shutdown on a watcher that hasn't been
+// started prevents it from starting.
+func (w *Watcher) Shutdown(ctx context.Context) error {
+	w.context()
+	w.cancel()
+
+	w.lock.RLock()
+	started := w.started
+	w.lock.RUnlock()
+
+	if !started {
+		return nil
+	}
+
+	select {
+	case <-w.finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops a running watcher and waits for all of its services to stop.
+// It is equivalent to calling Shutdown with a context that is never done.
+func (w *Watcher) Close() error {
+	return w.Shutdown(context.Background())
+}
+
+// stopServices stops all running services and waits for them to exit.
+func (w *Watcher) stopServices() {
+	w.lock.RLock()
+	services := make([]*service, 0, len(w.services))
+	for _, s := range w.services {
+		services = append(services, s)
+	}
+	w.lock.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, s := range services {
+		wg.Add(1)
+		go func(s *service) {
+			defer wg.Done()
+			s.Stop()
+		}(s)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,54 @@
+package gowatch_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/rfratto/gowatch"
+)
+
+func TestShutdown(t *testing.T) {
+	p, err := ioutil.TempDir("", "gowatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(p)
+
+	if err := ioutil.WriteFile(path.Join(p, "main.go"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := gowatch.NewWatcher(p, gowatch.Config{
+		Services:     map[string]gowatch.Service{"sleep": {Script: gowatch.Script{Run: "sleep 30"}}},
+		StartupSteps: []string{"sleep"},
+		FileTriggers: []gowatch.FileTrigger{
+			{Include: []string{"*.go"}, Triggers: []gowatch.Step{{Trigger: "sleep"}}},
+		},
+	})
+
+	ch := w.Subscribe()
+	defer w.Unsubscribe(ch)
+
+	errs := make(chan error, 1)
+	go func() { errs <- w.Start() }()
+
+	for ev := nextEvent(t, ch); ev.Type != gowatch.EventServiceStarted; ev = nextEvent(t, ch) {
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after Close")
+	}
+
+	if state, _ := w.ServiceState("sleep"); state != gowatch.ServiceStopped {
+		t.Errorf("expected service to be %s, got %s", gowatch.ServiceStopped, state)
+	}
+}
@@ -1,12 +1,13 @@
 package gowatch
 
 import (
+	"fmt"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 
-	"github.com/bmatcuk/doublestar"
+	"github.com/fsnotify/fsnotify"
 )
 
 // A FileTrigger is a pattern of whitelisted and blacklisted files that
@@ -21,39 +22,282 @@ type FileTrigger struct {
 	// is activated.
 	Exclude []string `yaml:"exclude"`
 
-	// Triggers holds the list of scripts and services to trigger when the
-	// file trigger is detected.
-	Triggers []string `yaml:"trigger"`
+	// Triggers holds the sequence of scripts and services to trigger when
+	// the file trigger is detected.
+	Triggers []Step `yaml:"trigger"`
+
+	// Events holds the kinds of file changes the file trigger responds to.
+	// Defaults to every kind except OpChmod.
+	Events []FileOp `yaml:"events"`
+
+	// Batching overrides the batching settings of the config for changes
+	// matched by this file trigger. Unset fields use the config's settings.
+	Batching Batching `yaml:",inline"`
+
+	// OnBusy determines what happens when files change while the steps
+	// triggered by this file trigger are still running. Defaults to
+	// BusyRestart.
+	OnBusy BusyPolicy `yaml:"on_busy"`
+
+	// UseIgnoreFiles overrides Config.UseIgnoreFiles for this file trigger
+	// when set.
+	UseIgnoreFiles *bool `yaml:"use_ignore_files"`
+
+	// compiled is set for the file triggers of a watcher's config when it
+	// is started or reloaded.
+	compiled *compiledTrigger
 }
 
-// Matches takes an path to a file and returns whether or not that path
-// is included in the current trigger.
-func (t *FileTrigger) Matches(root string, path string) bool {
-	// Get containing directory of path
-	dir := path
-	if !isDir(path) {
-		dir = filepath.Dir(path)
+// A compiledTrigger is what a file trigger needs to match paths, worked out
+// once per config load rather than for every file event.
+type compiledTrigger struct {
+	root     string
+	patterns patternList
+
+	// ignore returns the ignore rules that apply to the file trigger. It
+	// is nil if the file trigger doesn't use ignore files.
+	ignore func() *ignoreRules
+}
+
+// ignoreRules returns the ignore rules that apply to the file trigger.
+func (c *compiledTrigger) ignoreRules() *ignoreRules {
+	if c.ignore == nil {
+		return nil
 	}
+	return c.ignore()
+}
 
-	watched := t.watchedPaths(root)
-	for _, w := range watched {
-		if w == path || w == dir {
-			return true
+// compile compiles the patterns of the file trigger for paths under root.
+func (t *FileTrigger) compile(root string, ignore func() *ignoreRules) *compiledTrigger {
+	return &compiledTrigger{
+		root:     root,
+		patterns: compilePatterns(root, t.Include, t.Exclude),
+		ignore:   ignore,
+	}
+}
+
+// usesIgnoreFiles returns whether the file trigger skips ignored files,
+// given the setting of the config.
+func (t *FileTrigger) usesIgnoreFiles(def bool) bool {
+	if t.UseIgnoreFiles != nil {
+		return *t.UseIgnoreFiles
+	}
+	return def
+}
+
+// BusyPolicy determines what happens to new file changes while a trigger
+// sequence is running.
+type BusyPolicy string
+
+const (
+	// BusyRestart cancels the running trigger sequence and starts a new one
+	// for the new changes.
+	BusyRestart BusyPolicy = "restart"
+
+	// BusyQueue lets the running trigger sequence finish and then runs
+	// once more for all changes that happened in the meantime.
+	BusyQueue BusyPolicy = "queue"
+
+	// BusyIgnore lets the running trigger sequence finish and drops the
+	// new changes.
+	BusyIgnore BusyPolicy = "ignore"
+
+	// BusySignal sends SIGHUP to the processes of the running step and
+	// drops the new changes: the batch that arrived while busy is thrown
+	// away and does not cause another run. It suits scripts that reload on
+	// SIGHUP.
+	BusySignal BusyPolicy = "signal"
+)
+
+func (p BusyPolicy) validate() error {
+	switch p {
+	case "", BusyRestart, BusyQueue, BusyIgnore, BusySignal:
+		return nil
+	default:
+		return fmt.Errorf("unknown on_busy policy %q; expected restart, queue, ignore, or signal", p)
+	}
+}
+
+// busyPolicyFor returns the policy of a trigger sequence ran for changes
+// matched by file triggers with the given policies. The policy that keeps
+// the running sequence safest wins: queue, then ignore, then signal, then
+// restart.
+func busyPolicyFor(policies []BusyPolicy) BusyPolicy {
+	policy := BusyRestart
+	for _, p := range policies {
+		switch {
+		case p == BusyQueue:
+			return BusyQueue
+		case p == BusyIgnore:
+			policy = BusyIgnore
+		case p == BusySignal && policy == BusyRestart:
+			policy = BusySignal
 		}
 	}
+	return policy
+}
+
+// A Step is an entry in a sequence of triggers. It either runs a single
+// trigger or a group of triggers in parallel. In YAML, a single trigger is
+// written as its name and a group as an object with a parallel list:
+//
+//	trigger:
+//	  - parallel: [vet, lint, test]
+//	  - install
+type Step struct {
+	// Trigger holds the name of the trigger to run.
+	Trigger string
+
+	// Parallel holds the names of triggers to run at the same time. The
+	// step finishes once all of them have finished.
+	Parallel []string
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (s *Step) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var trigger string
+	if err := unmarshal(&trigger); err == nil {
+		*s = Step{Trigger: trigger}
+		return nil
+	}
+
+	var group struct {
+		Parallel []string `yaml:"parallel"`
+	}
+	if err := unmarshal(&group); err != nil {
+		return err
+	}
+
+	*s = Step{Parallel: group.Parallel}
+	return nil
+}
+
+// Names returns the names of the triggers ran by the step.
+func (s Step) Names() []string {
+	if s.Parallel != nil {
+		return s.Parallel
+	}
+	return []string{s.Trigger}
+}
+
+func (s Step) validate() error {
+	if s.Parallel != nil && s.Trigger != "" {
+		return fmt.Errorf("step must not set both a trigger and parallel triggers")
+	} else if s.Parallel == nil && s.Trigger == "" {
+		return fmt.Errorf("step must have a trigger")
+	} else if s.Parallel != nil && len(s.Parallel) == 0 {
+		return fmt.Errorf("parallel step must list at least one trigger")
+	}
+
+	return nil
+}
 
+// FileOp is a kind of change to a file.
+type FileOp string
+
+const (
+	// OpCreate is a file being created.
+	OpCreate FileOp = "create"
+
+	// OpWrite is a file being written to.
+	OpWrite FileOp = "write"
+
+	// OpRemove is a file being removed.
+	OpRemove FileOp = "remove"
+
+	// OpRename is a file being renamed. The change is reported for the old
+	// name of the file; the new name is reported as OpCreate.
+	OpRename FileOp = "rename"
+
+	// OpChmod is the permissions of a file being changed.
+	OpChmod FileOp = "chmod"
+)
+
+// fileOps lists every FileOp in the order they are reported in.
+var fileOps = []FileOp{OpCreate, OpWrite, OpRemove, OpRename, OpChmod}
+
+func (op FileOp) validate() error {
+	for _, known := range fileOps {
+		if op == known {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown event %q; expected one of create, write, remove, rename, or chmod", op)
+}
+
+// fileOpsFromEvent returns the kinds of changes in an fsnotify event.
+func fileOpsFromEvent(op fsnotify.Op) []FileOp {
+	var ops []FileOp
+	if op&fsnotify.Create != 0 {
+		ops = append(ops, OpCreate)
+	}
+	if op&fsnotify.Write != 0 {
+		ops = append(ops, OpWrite)
+	}
+	if op&fsnotify.Remove != 0 {
+		ops = append(ops, OpRemove)
+	}
+	if op&fsnotify.Rename != 0 {
+		ops = append(ops, OpRename)
+	}
+	if op&fsnotify.Chmod != 0 {
+		ops = append(ops, OpChmod)
+	}
+	return ops
+}
+
+// Accepts returns whether the file trigger responds to op.
+func (t *FileTrigger) Accepts(op FileOp) bool {
+	if len(t.Events) == 0 {
+		return op != OpChmod
+	}
+
+	for _, e := range t.Events {
+		if e == op {
+			return true
+		}
+	}
 	return false
 }
 
-func (t *FileTrigger) watchedPaths(root string) []string {
+// Matches takes an path to a file and returns whether or not that path
+// is included in the current trigger. The file triggers in the config of a
+// started watcher are compiled once and share the ignore rules cached by
+// the watcher. Other file triggers are compiled, and their ignore files
+// read, on every call.
+func (t *FileTrigger) Matches(root string, path string) bool {
+	if t.compiled == nil || t.compiled.root != root {
+		var ignore func() *ignoreRules
+		if t.usesIgnoreFiles(false) {
+			ignore = func() *ignoreRules { return loadIgnoreRules(root) }
+		}
+
+		compiled := *t
+		compiled.compiled = t.compile(root, ignore)
+		return compiled.matches(path)
+	}
+
+	return t.matches(path)
+}
+
+// matches returns whether path is included by the compiled file trigger.
+func (t *FileTrigger) matches(path string) bool {
+	if len(t.Triggers) == 0 || t.compiled.ignoreRules().ignored(path) {
+		return false
+	}
+
+	return t.compiled.patterns.match(path, isDir(path))
+}
+
+// watchedPaths returns the existing paths included by the compiled file
+// trigger.
+func (t *FileTrigger) watchedPaths() []string {
 	if len(t.Triggers) == 0 {
 		return nil
 	}
 
-	absInc := makeAbsolute(root, t.Include)
-	absExc := makeAbsolute(root, t.Exclude)
-
-	return findAbsolutes(absInc, absExc)
+	return findAbsolutes(t.compiled.patterns, t.compiled.ignoreRules())
 }
 
 func contains(list []string, entry string) bool {
@@ -86,19 +330,6 @@ func makeAbsolute(root string, patterns []string) []string {
 	return absPatterns
 }
 
-func getDirs(paths []string) []string {
-	dirs := []string{}
-	for _, path := range paths {
-		if isDir(path) {
-			dirs = append(dirs, path)
-		} else {
-			dirs = append(dirs, filepath.Dir(path))
-		}
-	}
-
-	return fixDirectories(dirs)
-}
-
 func isDir(path string) bool {
 	fi, err := os.Stat(path)
 	if err != nil {
@@ -108,73 +339,14 @@ func isDir(path string) bool {
 	return fi.IsDir()
 }
 
-func findAbsolutes(inc []string, exc []string) []string {
-	getAbsolutePatterns := func(in []string) []string {
-		out := []string{}
-		for _, i := range in {
-			if filepath.IsAbs(i) {
-				out = append(out, i)
-			}
-		}
-		return out
-	}
-
-	expandPatterns := func(in []string) []string {
-		matches := []string{}
-		for _, i := range in {
-			mm, _ := doublestar.Glob(i)
-
-			// If our pattern ends in a /, only add
-			// directories.
-			if strings.HasSuffix(i, "/") {
-				for _, m := range mm {
-					if isDir(m) {
-						matches = append(matches, m)
-					}
-				}
-				continue
-			}
-
-			matches = append(matches, mm...)
-		}
-		return matches
-	}
-
-	// substr returns true if str is a suffix of any
-	// element in strs.
-	substr := func(str string, strs []string) bool {
-		for _, prefix := range strs {
-			if strings.HasPrefix(str, prefix) {
-				return true
-			}
+// findAbsolutes returns the existing paths included by patterns that are not
+// ignored.
+func findAbsolutes(patterns patternList, ignore *ignoreRules) []string {
+	matches := []string{}
+	for _, m := range patterns.expand() {
+		if !ignore.ignored(m) {
+			matches = append(matches, m)
 		}
-
-		return false
 	}
-
-	// diff gets elements of inc that are not in exc or are
-	// subpaths of any element in exc.
-	diff := func(inc []string, exc []string) []string {
-		excludedMap := make(map[string]bool)
-		for _, e := range exc {
-			excludedMap[e] = true
-		}
-
-		ret := []string{}
-		for _, i := range inc {
-			if _, ok := excludedMap[i]; !ok && !substr(i, exc) {
-				ret = append(ret, i)
-			}
-		}
-
-		return ret
-	}
-
-	// get include matches and exclude matches for
-	// absolute inc/exc patterns
-	imatches := expandPatterns(getAbsolutePatterns(inc))
-	ematches := expandPatterns(getAbsolutePatterns(exc))
-
-	// get matches that aren't excluded
-	return diff(imatches, ematches)
+	return matches
 }
@@ -34,7 +34,7 @@ func getFtWatcher(inc []string, exc []string) gowatch.Watcher {
 				{
 					Include:  inc,
 					Exclude:  exc,
-					Triggers: []string{"foo", "bar"},
+					Triggers: []gowatch.Step{{Trigger: "foo"}, {Trigger: "bar"}},
 				},
 			},
 		},
@@ -206,6 +206,11 @@ func TestWatchedPaths(t *testing.T) {
 			path.Join(wd, "src"),
 			path.Join(wd, "src", "lib"),
 		}},
+		{"negation", []string{"./", "**/", "!src/", "src/lib/"}, []string{
+			wd,
+			path.Join(wd, "node_modules"),
+			path.Join(wd, "src", "lib"),
+		}},
 	}
 
 	for _, tc := range tt {
@@ -225,3 +230,28 @@ func TestWatchedPathsIgnored(t *testing.T) {
 
 	compareWatched(t, actual, expect)
 }
+
+func TestFileTriggerAccepts(t *testing.T) {
+	tt := []struct {
+		name   string
+		events []gowatch.FileOp
+		op     gowatch.FileOp
+		accept bool
+	}{
+		{"default write", nil, gowatch.OpWrite, true},
+		{"default create", nil, gowatch.OpCreate, true},
+		{"default chmod", nil, gowatch.OpChmod, false},
+		{"listed", []gowatch.FileOp{gowatch.OpCreate}, gowatch.OpCreate, true},
+		{"not listed", []gowatch.FileOp{gowatch.OpCreate}, gowatch.OpWrite, false},
+		{"chmod listed", []gowatch.FileOp{gowatch.OpChmod}, gowatch.OpChmod, true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			ft := gowatch.FileTrigger{Events: tc.events}
+			if accept := ft.Accepts(tc.op); accept != tc.accept {
+				t.Errorf("expected Accepts(%s) to be %v, got %v", tc.op, tc.accept, accept)
+			}
+		})
+	}
+}
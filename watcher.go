@@ -1,18 +1,21 @@
 package gowatch
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
-	"mvdan.cc/sh/interp"
-	"mvdan.cc/sh/syntax"
 )
 
 type triggerWriter struct {
@@ -22,29 +25,30 @@ type triggerWriter struct {
 	wroteHeader bool
 }
 
-func (t *triggerWriter) writeHeader() {
-	bb := fmt.Sprintf("[%s] ", t.Name)
-	t.w.Write([]byte(bb))
-	t.wroteHeader = true
-}
-
+// Write writes p with the header at the start of every line. Each line is
+// written with a single call to the underlying writer, so lines written by
+// triggers running in parallel don't get mixed up.
 func (t *triggerWriter) Write(p []byte) (n int, err error) {
 	total := 0
-	for _, b := range p {
-		// Write the header every time a newline is written
+	for len(p) > 0 {
+		line := p
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			line = p[:i+1]
+		}
+		p = p[len(line):]
+
+		buf := line
 		if !t.wroteHeader {
-			t.writeHeader()
+			buf = append([]byte(fmt.Sprintf("[%s] ", t.Name)), line...)
 		}
 
-		n, err := t.w.Write([]byte{b})
-		if err != nil {
+		if _, err := t.w.Write(buf); err != nil {
 			return total, err
 		}
-		total += n
+		total += len(line)
 
-		if b == '\n' {
-			t.wroteHeader = false
-		}
+		// Write the header again once a newline is written
+		t.wroteHeader = line[len(line)-1] != '\n'
 	}
 
 	return total, nil
@@ -68,9 +72,47 @@ type Watcher struct {
 	// Config of file triggers and events to run
 	Config Config
 
+	// NewBackend optionally creates the Backend used to watch for file
+	// changes, overriding Config.Backend.
+	NewBackend func() (Backend, error)
+
 	services map[string]*service
-	files    map[string]*syntax.File
+	actions  map[string]*action
 	ctx      context.Context
+	events   eventHub
+
+	// runCtx is derived from ctx and is cancelled by Shutdown. finished is
+	// closed once a started watcher has stopped all of its services. All
+	// three are created by context.
+	runCtx   context.Context
+	cancel   context.CancelFunc
+	finished chan struct{}
+	initOnce sync.Once
+	started  bool
+
+	// lock guards Config, services, and actions, which may be replaced while
+	// the watcher is running by Reload.
+	lock sync.RWMutex
+
+	// rescan is signalled to have the watcher update the set of watched
+	// paths immediately.
+	rescan chan struct{}
+
+	// pauseC is signalled by Pause to have the watch loop drop the changes
+	// it hasn't acted on yet.
+	pauseC chan struct{}
+
+	paused    bool
+	pauseLock sync.Mutex
+
+	// fs picks how each watched directory is watched. It is created by
+	// Start.
+	fs *fsInspector
+
+	// ignore caches the rules of the ignore files in Directory. It is nil
+	// until a file trigger uses them.
+	ignore     *ignoreRules
+	ignoreLock sync.Mutex
 }
 
 func (w *Watcher) parseTriggerName(orig string) (trigger string, action string) {
@@ -84,11 +126,41 @@ func (w *Watcher) parseTriggerName(orig string) (trigger string, action string)
 	return
 }
 
+func (w *Watcher) validateFileTriggers() error {
+	if err := w.Config.Batching.validate(); err != nil {
+		return fmt.Errorf("invalid batching settings: %v", err)
+	}
+
+	for i, ft := range w.Config.FileTriggers {
+		for _, op := range ft.Events {
+			if err := op.validate(); err != nil {
+				return fmt.Errorf("invalid events for file trigger %d: %v", i, err)
+			}
+		}
+
+		for _, step := range ft.Triggers {
+			if err := step.validate(); err != nil {
+				return fmt.Errorf("invalid file trigger %d: %v", i, err)
+			}
+		}
+
+		if err := ft.Batching.validate(); err != nil {
+			return fmt.Errorf("invalid batching settings for file trigger %d: %v", i, err)
+		} else if err := ft.OnBusy.validate(); err != nil {
+			return fmt.Errorf("invalid file trigger %d: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
 func (w *Watcher) validateTriggerNames() error {
 	// Get a list of all triggers
-	allTriggers := w.Config.StartupSteps
+	allTriggers := append([]string{}, w.Config.StartupSteps...)
 	for _, ft := range w.Config.FileTriggers {
-		allTriggers = append(allTriggers, ft.Triggers...)
+		for _, step := range ft.Triggers {
+			allTriggers = append(allTriggers, step.Names()...)
+		}
 	}
 
 	invalidTriggers := []string{}
@@ -129,7 +201,7 @@ outer:
 	return nil
 }
 
-func (w Watcher) validateActionNames() error {
+func (w *Watcher) validateActionNames() error {
 	invalid := []string{}
 
 	for action := range w.Config.Actions {
@@ -191,14 +263,102 @@ outer:
 	return nil
 }
 
+// validateScriptEnv checks that the directory and environment of a script
+// are usable.
+func (w *Watcher) validateScriptEnv(s Script) error {
+	if dir := w.scriptDir(s); dir != "" && !isDir(dir) {
+		return fmt.Errorf("dir %s is not a directory", dir)
+	}
+
+	for name := range s.Env {
+		if !validEnvName(name) {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+	}
+
+	if s.EnvFile != "" {
+		if _, err := loadEnvFile(w.Directory, s.EnvFile); err != nil {
+			return fmt.Errorf("failed to load env_file: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (w *Watcher) validateScripts() error {
+	global := Script{Env: w.Config.Env, EnvFile: w.Config.EnvFile}
+	if err := w.validateScriptEnv(global); err != nil {
+		return err
+	}
+
+	for name, action := range w.Config.Actions {
+		if err := action.validate(); err != nil {
+			return fmt.Errorf("invalid action %s: %v", name, err)
+		} else if err := w.validateScriptEnv(action); err != nil {
+			return fmt.Errorf("invalid action %s: %v", name, err)
+		}
+	}
+
+	for name, service := range w.Config.Services {
+		if err := service.Script.validate(); err != nil {
+			return fmt.Errorf("invalid service %s: %v", name, err)
+		} else if err := w.validateScriptEnv(service.Script); err != nil {
+			return fmt.Errorf("invalid service %s: %v", name, err)
+		} else if len(service.Script.Inputs) > 0 {
+			return fmt.Errorf("invalid service %s: inputs are only supported for actions", name)
+		}
+	}
+
+	return nil
+}
+
+func (w *Watcher) validateServiceSettings() error {
+	for name, service := range w.Config.Services {
+		if service.Ready != nil {
+			if err := service.Ready.validate(); err != nil {
+				return fmt.Errorf("invalid readiness probe for service %s: %v", name, err)
+			}
+		}
+
+		if err := service.Restart.validate(); err != nil {
+			return fmt.Errorf("invalid restart policy for service %s: %v", name, err)
+		} else if service.MaxRestarts < 0 {
+			return fmt.Errorf("max_restarts for service %s must not be negative", name)
+		} else if err := service.Backoff.validate(); err != nil {
+			return fmt.Errorf("invalid backoff for service %s: %v", name, err)
+		} else if _, err := parseSignal(service.StopSignal); err != nil {
+			return fmt.Errorf("invalid stop_signal for service %s: %v", name, err)
+		} else if service.StopTimeout < 0 {
+			return fmt.Errorf("stop_timeout for service %s must not be negative", name)
+		}
+	}
+
+	return nil
+}
+
+func (w *Watcher) validateBackend() error {
+	if err := w.Config.Backend.validate(); err != nil {
+		return err
+	} else if w.Config.PollInterval < 0 {
+		return fmt.Errorf("poll_interval must not be negative")
+	}
+
+	return nil
+}
+
 // Validate validates the configuration file and returns any errors.
 func (w *Watcher) Validate() error {
 	type validateFunc func() error
 
 	validations := []validateFunc{
 		w.validateTriggerNames,
+		w.validateFileTriggers,
 		w.validateServiceUniqueness,
 		w.validateActionNames,
+		w.validateScripts,
+		w.validateDependencies,
+		w.validateServiceSettings,
+		w.validateBackend,
 	}
 
 	for _, validation := range validations {
@@ -210,141 +370,288 @@ func (w *Watcher) Validate() error {
 	return nil
 }
 
-func (w *Watcher) watchForNewPatterns(init []string, n *fsnotify.Watcher) {
-	watchedMap := make(map[string]bool)
-	for _, p := range init {
-		watchedMap[p] = true
+// watchForRescans updates the watched directories whenever a rescan is
+// requested, such as when the file triggers are changed by Reload.
+func (w *Watcher) watchForRescans(ws *watchSet) {
+	for {
+		select {
+		case <-w.rescan:
+			ws.sync(w.watchedDirs())
+		case <-w.context().Done():
+			return
+		}
 	}
+}
 
-	reloadPatterns := func() {
-		latest := w.WatchedPaths()
-		addedPaths := []string{}
-		for _, p := range latest {
-			_, exists := watchedMap[p]
-			if !exists {
-				addedPaths = append(addedPaths, p)
-			}
+// watchNewDir starts watching a directory that was just created and the
+// directories below it, and returns the matches for the files already in
+// it, since they may have been created before the watch was added.
+func (w *Watcher) watchNewDir(ws *watchSet, dir string) []eventMatch {
+	var (
+		dirs    []string
+		matches []eventMatch
+	)
 
-			watchedMap[p] = true
+	w.walkWatched(dir, func(p string, isDir bool) {
+		if isDir {
+			dirs = append(dirs, p)
+			return
 		}
+		matches = append(matches, w.matchEvent(fsnotify.Event{Name: p, Op: fsnotify.Create})...)
+	})
 
-		if len(addedPaths) > 0 {
-			watched := uniqueStringSlice(getDirs(addedPaths))
-			for _, p := range watched {
-				if err := n.Add(p); err != nil {
-					fmt.Fprintf(w.Debug, "failed to add new path %s: %v\n", p, err)
-				} else {
-					fmt.Fprintf(w.Debug, "watching new path %s\n", p)
-				}
+	if err := ws.add(w.fs.expand(dirs)); err != nil {
+		fmt.Fprintln(w.Debug, err)
+	}
+	return matches
+}
+
+// A sequence is a running trigger sequence started by file changes.
+type sequence struct {
+	policy BusyPolicy
+	cancel context.CancelFunc
+	procs  *processSet
+	done   chan struct{}
+}
+
+func (w *Watcher) watchLoop(n Backend, ws *watchSet) error {
+	var (
+		pending    batches
+		running    *sequence
+		runningC   <-chan struct{}
+		queued     []fileChange
+		handlers   sync.WaitGroup
+		flushTimer = time.NewTimer(0)
+		flushC     <-chan time.Time
+		sums       digests
+	)
+
+	if w.config().SkipUnchanged {
+		sums = make(digests)
+		sums.seed(ws.list())
+	}
+
+	// schedule sets flushTimer to fire when the next batch is due.
+	schedule := func() {
+		if !flushTimer.Stop() {
+			select {
+			case <-flushTimer.C:
+			default:
 			}
 		}
+
+		flushC = nil
+		if next, ok := pending.next(); ok {
+			flushTimer.Reset(time.Until(next))
+			flushC = flushTimer.C
+		}
 	}
+	schedule()
 
-	// New files may be added at any point while gowatch continues to run.
-	// Every 1s we'll see if there are any new files that've been added so we
-	// can tell the watcher to include them.
-	for {
-		select {
-		case <-time.After(1 * time.Second):
-			reloadPatterns()
-		case <-w.ctx.Done():
+	// start runs the steps triggered by a list of file changes, replacing
+	// the running sequence.
+	start := func(due []fileChange) {
+		changes := w.changeSetFor(due)
+		if len(changes.steps) == 0 {
 			return
 		}
-	}
-}
 
-func (w *Watcher) watchLoop(n *fsnotify.Watcher) error {
-	eventsBuffer := []string{}
+		var policies []BusyPolicy
+		for _, c := range due {
+			policies = append(policies, c.onBusy)
+		}
 
-	var (
-		handlerContext context.Context
-		handlerCancel  context.CancelFunc
-		flushTimer     <-chan time.Time
-	)
+		ctx, cancel := context.WithCancel(w.context())
+		seq := &sequence{
+			policy: busyPolicyFor(policies),
+			cancel: cancel,
+			procs:  &processSet{},
+			done:   make(chan struct{}),
+		}
+		running, runningC = seq, seq.done
+
+		// Only sequences that may be signalled track their processes.
+		if seq.policy == BusySignal {
+			ctx = withProcessSet(ctx, seq.procs)
+		}
+
+		handlers.Add(1)
+		go func() {
+			defer handlers.Done()
+			defer close(seq.done)
+			w.handleFilesChanged(ctx, changes)
+		}()
+	}
 
 	for {
 		select {
-		case ev := <-n.Events:
-			if ev.Op == fsnotify.Chmod {
-				break
+		case ev := <-n.Events():
+			if isIgnoreFile(w.Directory, ev.Name) {
+				w.resetIgnoreRules()
+
+				// Have the watch set updated for the new rules.
+				select {
+				case w.rescan <- struct{}{}:
+				default:
+				}
+			}
+
+			// Keep the watch set up to date while paused, so changes
+			// are seen again once resumed.
+			if ev.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				ws.forget(ev.Name)
+			}
+
+			var created []eventMatch
+			if ev.Op&fsnotify.Create != 0 && isDir(ev.Name) {
+				created = w.watchNewDir(ws, ev.Name)
 			}
 
-			eventsBuffer = append(eventsBuffer, ev.Name)
+			if w.Paused() {
+				break
+			}
 
-			if flushTimer == nil {
-				flushTimer = time.After(250 * time.Millisecond)
+			matches := append(w.matchEvent(ev), created...)
+			now := time.Now()
+			for _, m := range matches {
+				pending.add(m.settings, m.change, now)
 			}
-		case err := <-n.Errors:
+			schedule()
+		case <-w.pauseC:
+			pending.clear()
+			queued = nil
+			schedule()
+		case err := <-n.Errors():
 			fmt.Println(err)
-		case <-flushTimer:
-			if len(w.triggersForFiles(eventsBuffer)) == 0 {
-				eventsBuffer = []string{}
-				flushTimer = nil
+		case <-flushC:
+			due := pending.flush(time.Now())
+			schedule()
+
+			// Pause may not have been seen through pauseC yet.
+			if w.Paused() {
+				continue
+			}
+
+			// Digests are dropped while the check is disabled, since they
+			// would go stale if it was enabled again by a reload.
+			if !w.config().SkipUnchanged {
+				sums = nil
+			} else if len(due) > 0 {
+				if sums == nil {
+					sums = make(digests)
+				}
+				due = sums.filter(w.Debug, due)
+			}
+
+			if len(due) == 0 {
 				continue
 			}
 
-			if handlerCancel != nil {
-				handlerCancel()
+			if running == nil {
+				start(due)
+				continue
 			}
 
-			handlerContext, handlerCancel = context.WithCancel(context.Background())
+			switch running.policy {
+			case BusyQueue:
+				fmt.Fprintln(w.Debug, "busy; queueing changes until the running triggers finish")
+				queued = append(queued, due...)
+			case BusyIgnore:
+				fmt.Fprintln(w.Debug, "busy; ignoring changes")
+			case BusySignal:
+				fmt.Fprintln(w.Debug, "busy; sending SIGHUP to the running trigger")
+				running.procs.Signal(syscall.SIGHUP)
+			default:
+				running.cancel()
+				start(due)
+			}
+		case <-runningC:
+			running.cancel()
+			running, runningC = nil, nil
 
-			go w.handleFilesChanged(handlerContext, eventsBuffer)
-			eventsBuffer = []string{}
-			flushTimer = nil
-		case <-w.ctx.Done():
-			if handlerCancel != nil {
-				handlerCancel()
+			if len(queued) > 0 {
+				start(queued)
+				queued = nil
+			}
+		case <-w.context().Done():
+			if running != nil {
+				running.cancel()
 			}
-			return w.ctx.Err()
+
+			// Let the cancelled trigger sequence finish before services
+			// are stopped, so it can't start one of them again.
+			handlers.Wait()
+			return w.context().Err()
 		}
 	}
 }
 
 // Start starts the watcher. Start should not exit normally unless an error occurred or
-// the watcher is cancelled through the context passed to NewWatchWithContext.
+// the watcher is cancelled through the context passed to NewWatchWithContext or
+// Shutdown. Before returning, all services are stopped.
 func (w *Watcher) Start() error {
+	ctx := w.context()
+
 	if err := w.Validate(); err != nil {
 		return err
 	}
 
-	if err := w.compileFiles(); err != nil {
+	actions, err := w.compileActions(w.Config)
+	if err != nil {
 		return err
-	} else if err := w.compileServices(); err != nil {
+	}
+
+	services, err := w.compileServices(w.Config)
+	if err != nil {
 		return err
 	}
 
+	w.lock.Lock()
+	w.Config.FileTriggers = w.compileFileTriggers(w.Config)
+	w.actions, w.services = actions, services
+	w.rescan = make(chan struct{}, 1)
+	w.pauseC = make(chan struct{}, 1)
+	w.started = true
+	w.lock.Unlock()
+
+	defer close(w.finished)
+	defer w.stopServices()
+
 	// Before we start the watcher, run all the startup triggers
 	for _, start := range w.Config.StartupSteps {
-		err := w.Run(context.Background(), start)
-		if err != nil {
+		err := w.Run(ctx, start)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		} else if err != nil {
 			return fmt.Errorf("startup trigger %s failed: %v", start, err)
 		}
 	}
 
-	n, err := fsnotify.NewWatcher()
+	w.fs = newFSInspector(w.Debug)
+
+	n, err := w.newBackend(w.fs)
 	if err != nil {
 		return fmt.Errorf("unable to start watcher: %v", err)
 	}
+	defer n.Close()
 
-	paths := w.WatchedPaths()
-	watched := uniqueStringSlice(getDirs(paths))
+	watched := w.watchedDirs()
 	if len(watched) == 0 {
 		return fmt.Errorf("no paths to watch")
 	}
 
-	for _, p := range watched {
-		if err := n.Add(p); err != nil {
-			return err
-		}
+	ws := newWatchSet(n, w.Debug)
+	ws.pollOnLimit = w.config().PollOnWatchLimit
+	if err := ws.add(watched); err != nil {
+		return err
 	}
 
-	go w.watchForNewPatterns(paths, n)
-	return w.watchLoop(n)
+	go w.watchForRescans(ws)
+	return w.watchLoop(n, ws)
 }
 
 func (w *Watcher) stopService(ctx context.Context, trigger string) error {
-	s, ok := w.services[trigger]
+	s, ok := w.service(trigger)
 	if !ok {
 		return fmt.Errorf("no service named %s found", trigger)
 	}
@@ -354,59 +661,189 @@ func (w *Watcher) stopService(ctx context.Context, trigger string) error {
 	return nil
 }
 
-func (w *Watcher) runService(ctx context.Context, trigger string) error {
-	s, ok := w.services[trigger]
+func (w *Watcher) runService(ctx context.Context, trigger string, changes *changeSet) error {
+	s, ok := w.service(trigger)
 	if !ok {
 		return fmt.Errorf("no service named %s found", trigger)
 	}
 
+	base, err := w.scriptEnv(w.config(), w.config().Services[trigger].Script)
+	if err != nil {
+		return err
+	}
+	changed, err := changes.env(trigger)
+	if err != nil {
+		return err
+	}
+	env := mergeEnv(base, changed)
+
 	// Stop the service. Fails if it's not running, but we don't care.
 	s.Stop()
+	s.setStartedEnv(base)
 
-	tout := &triggerWriter{Name: trigger, w: w.Stdout}
-	terr := &triggerWriter{Name: trigger, w: w.Stderr}
+	var (
+		tout io.Writer = &triggerWriter{Name: trigger, w: w.Stdout}
+		terr io.Writer = &triggerWriter{Name: trigger, w: w.Stderr}
+		logs <-chan struct{}
+	)
+
+	if s.Ready != nil && s.Ready.pattern != nil {
+		tout, terr, logs = newLineMatchers(s.Ready.pattern, tout, terr)
+	}
+
+	// The service keeps using the list of changed files after the trigger
+	// sequence is done, including when it is restarted, so the list is only
+	// removed once the service stops.
+	list := changes.detach(trigger)
 
 	// Start running the service in a new goroutine. We want to directly
 	// handle it being cancelled so we don't propagate the context above.
-	go s.Run(context.Background(), tout, terr)
+	exited := make(chan struct{})
+	go func() {
+		s.run(context.Background(), env, tout, terr, exited)
+		if list != "" {
+			os.Remove(list)
+		}
+	}()
+
+	if s.Ready == nil {
+		return nil
+	}
+
+	fmt.Fprintf(w.Debug, "[%s] WAITING FOR READINESS\n", trigger)
+	if err := s.Ready.Wait(ctx, s.Dir, env, logs, exited); err != nil {
+		return err
+	}
+	fmt.Fprintf(w.Debug, "[%s] READY\n", trigger)
+	w.emit(Event{Type: EventServiceReady, Trigger: trigger})
 	return nil
 }
 
-func (w *Watcher) runAction(ctx context.Context, trigger string) error {
-	f, ok := w.files[trigger]
+func (w *Watcher) runAction(ctx context.Context, trigger string, changes *changeSet) error {
+	a, ok := w.action(trigger)
 	if !ok {
 		return fmt.Errorf("no action named %s found", trigger)
 	}
 
+	script := w.config().Actions[trigger]
+
+	env, err := w.triggerEnv(script, trigger, changes)
+	if err != nil {
+		return err
+	}
+
+	var hash string
+	if len(script.Inputs) > 0 {
+		hash, err = w.inputsHash(script)
+		if err != nil {
+			return fmt.Errorf("failed to hash inputs: %v", err)
+		}
+
+		if hash == w.cachedHash(trigger) {
+			fmt.Fprintf(w.Debug, "[%s] UP TO DATE: inputs unchanged since last successful run\n", trigger)
+			return nil
+		}
+	}
+
 	tout := &triggerWriter{Name: trigger, w: w.Stdout}
 	terr := &triggerWriter{Name: trigger, w: w.Stderr}
 
-	runner, err := interp.New(
-		interp.Dir(w.Directory),
-		interp.StdIO(nil, tout, terr),
-	)
+	err = a.Run(ctx, env, tout, terr)
+	if err == nil && hash != "" {
+		if err := w.saveCachedHash(trigger, hash); err != nil {
+			fmt.Fprintf(w.Stderr, "[%s] failed to save cache: %v\n", trigger, err)
+		}
+	}
+	return err
+}
+
+// triggerEnv returns the environment variables to pass to the script of a
+// trigger on top of the environment of the current process. Variables are
+// taken from the following sources, with later sources taking precedence:
+// the global env_file, the global env, the script's env_file, the script's
+// env, and the description of the changed files that caused the trigger to
+// run.
+func (w *Watcher) triggerEnv(s Script, trigger string, changes *changeSet) ([]string, error) {
+	env, err := w.scriptEnv(w.config(), s)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	changed, err := changes.env(trigger)
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeEnv(env, changed), nil
+}
+
+// scriptEnv returns the variables set for s by the env files and env of cfg
+// and of s, with the env files loaded from disk.
+func (w *Watcher) scriptEnv(cfg Config, s Script) ([]string, error) {
+	var lists [][]string
+
+	if cfg.EnvFile != "" {
+		env, err := loadEnvFile(w.Directory, cfg.EnvFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load env_file: %v", err)
+		}
+		lists = append(lists, env)
+	}
+	lists = append(lists, envList(cfg.Env))
+
+	if s.EnvFile != "" {
+		env, err := loadEnvFile(w.Directory, s.EnvFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load env_file: %v", err)
+		}
+		lists = append(lists, env)
+	}
+	lists = append(lists, envList(s.Env))
+
+	return mergeEnv(lists...), nil
+}
+
+// scriptDir returns the directory a script should run in.
+func (w *Watcher) scriptDir(s Script) string {
+	if s.Dir == "" {
+		return w.Directory
+	} else if filepath.IsAbs(s.Dir) {
+		return s.Dir
 	}
 
-	return runner.Run(ctx, f)
+	return filepath.Join(w.Directory, s.Dir)
 }
 
 // Run runs a specific named trigger defined from the watcher's config. The trigger
-// can either be a service or an action.
+// can either be a service or an action. The dependencies of the trigger are ran
+// first.
 func (w *Watcher) Run(ctx context.Context, trigger string) error {
+	if name, action := w.parseTriggerName(trigger); action == "" {
+		ctx, _ = withDepRuns(ctx)
+		if err := w.runDependencies(ctx, name, nil); err != nil {
+			return err
+		}
+	}
+
+	return w.run(ctx, trigger, nil)
+}
+
+// run runs a named trigger without its dependencies, which the caller is
+// expected to have ran. If the trigger is being ran in response to file
+// changes, changes describes the batch of files that caused it to run.
+func (w *Watcher) run(ctx context.Context, trigger string, changes *changeSet) error {
 	trigger, action := w.parseTriggerName(trigger)
 
-	_, ok := w.files[trigger]
+	_, ok := w.action(trigger)
 	if ok {
 		if action != "" {
 			return fmt.Errorf("trigger verb %s not supported for actions", action)
 		}
 
-		return w.runAction(ctx, trigger)
+		return w.runAction(ctx, trigger, changes)
 	}
 
-	_, ok = w.services[trigger]
+	_, ok = w.service(trigger)
 	if ok {
 		if action == "stop" {
 			return w.stopService(ctx, trigger)
@@ -416,12 +853,157 @@ func (w *Watcher) Run(ctx context.Context, trigger string) error {
 			return fmt.Errorf("trigger verb %s not supported for actions", action)
 		}
 
-		return w.runService(ctx, trigger)
+		return w.runService(ctx, trigger, changes)
 	}
 
 	return fmt.Errorf("no action or service named %s found", trigger)
 }
 
+// describe returns the description of the action or service referenced by
+// trigger, if any.
+func (w *Watcher) describe(trigger string) string {
+	name, _ := w.parseTriggerName(trigger)
+
+	if a, ok := w.action(name); ok {
+		return a.Description
+	} else if s, ok := w.service(name); ok {
+		return s.Description
+	}
+
+	return ""
+}
+
+// ServiceState returns the current state of the named service. The watcher
+// must have been started.
+func (w *Watcher) ServiceState(name string) (ServiceState, error) {
+	s, ok := w.service(name)
+	if !ok {
+		return ServiceStopped, fmt.Errorf("no service named %s found", name)
+	}
+
+	return s.State(), nil
+}
+
+// ActionInfo describes an action defined in the watcher's config.
+type ActionInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// ServiceInfo describes a service defined in the watcher's config and what
+// it is currently doing.
+type ServiceInfo struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	State       ServiceState `json:"state"`
+}
+
+// Actions returns the actions defined in the watcher's config, sorted by
+// name.
+func (w *Watcher) Actions() []ActionInfo {
+	infos := []ActionInfo{}
+	for name, a := range w.config().Actions {
+		infos = append(infos, ActionInfo{Name: name, Description: a.Description})
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Services returns the services defined in the watcher's config along with
+// their current state, sorted by name.
+func (w *Watcher) Services() []ServiceInfo {
+	infos := []ServiceInfo{}
+	for name, s := range w.config().Services {
+		state, _ := w.ServiceState(name)
+		infos = append(infos, ServiceInfo{
+			Name:        name,
+			Description: s.Script.Description,
+			State:       state,
+		})
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// StartService starts the named service if it is not already running.
+func (w *Watcher) StartService(ctx context.Context, name string) error {
+	state, err := w.ServiceState(name)
+	if err != nil {
+		return err
+	}
+
+	switch state {
+	case ServiceRunning, ServiceRestarting, ServiceCrashLoop:
+		return nil
+	default:
+		return w.runStep(ctx, name, nil)
+	}
+}
+
+// RestartService starts the named service, stopping it first if it is
+// running.
+func (w *Watcher) RestartService(ctx context.Context, name string) error {
+	if _, err := w.ServiceState(name); err != nil {
+		return err
+	}
+
+	return w.runStep(ctx, name, nil)
+}
+
+// StopService stops the named service.
+func (w *Watcher) StopService(name string) error {
+	if _, err := w.ServiceState(name); err != nil {
+		return err
+	}
+
+	return w.runStep(context.Background(), name+":stop", nil)
+}
+
+// Pause stops the watcher from responding to file events until Resume is
+// called. File events that happen while paused are discarded, as are the
+// changes that were still waiting for their batch to be flushed or for the
+// running triggers to finish.
+func (w *Watcher) Pause() {
+	w.pauseLock.Lock()
+	defer w.pauseLock.Unlock()
+
+	if !w.paused {
+		fmt.Fprintln(w.Debug, "pausing file watching")
+		w.emit(Event{Type: EventWatchPaused})
+	}
+	w.paused = true
+
+	w.lock.RLock()
+	pauseC := w.pauseC
+	w.lock.RUnlock()
+
+	select {
+	case pauseC <- struct{}{}:
+	default:
+	}
+}
+
+// Resume resumes responding to file events after a call to Pause.
+func (w *Watcher) Resume() {
+	w.pauseLock.Lock()
+	defer w.pauseLock.Unlock()
+
+	if w.paused {
+		fmt.Fprintln(w.Debug, "resuming file watching")
+		w.emit(Event{Type: EventWatchResumed})
+	}
+	w.paused = false
+}
+
+// Paused returns whether file watching is paused.
+func (w *Watcher) Paused() bool {
+	w.pauseLock.Lock()
+	defer w.pauseLock.Unlock()
+	return w.paused
+}
+
 // MatchingTriggers takes a full path to a file and returns all trigers that
 // match that path.
 func (w *Watcher) MatchingTriggers(path string) (triggers []FileTrigger, err error) {
@@ -429,8 +1011,8 @@ func (w *Watcher) MatchingTriggers(path string) (triggers []FileTrigger, err err
 		return nil, fmt.Errorf("path must be absolute")
 	}
 
-	for _, t := range w.Config.FileTriggers {
-		if t.Matches(w.Directory, path) {
+	for _, t := range w.fileTriggers() {
+		if t.matches(path) {
 			triggers = append(triggers, t)
 		}
 	}
@@ -443,8 +1025,8 @@ func (w *Watcher) MatchingTriggers(path string) (triggers []FileTrigger, err err
 func (w *Watcher) WatchedPaths() []string {
 	matched := []string{}
 
-	for _, ft := range w.Config.FileTriggers {
-		ww := ft.watchedPaths(w.Directory)
+	for _, ft := range w.fileTriggers() {
+		ww := ft.watchedPaths()
 		for _, w := range ww {
 			matched = append(matched, w)
 		}
@@ -468,91 +1050,321 @@ func NewWatcherWithContext(ctx context.Context, dir string, config Config) *Watc
 	}
 }
 
+// context returns the context of the watcher, which is done once the
+// context the watcher was created with is done or Shutdown is called.
+func (w *Watcher) context() context.Context {
+	w.initOnce.Do(func() {
+		parent := w.ctx
+		if parent == nil {
+			parent = context.Background()
+		}
+
+		w.runCtx, w.cancel = context.WithCancel(parent)
+		w.finished = make(chan struct{})
+	})
+	return w.runCtx
+}
+
 // NewWatcher returns a new Watcher given a directory to watch and a
 // config with file patterns and triggers.
 func NewWatcher(dir string, config Config) *Watcher {
 	return NewWatcherWithContext(context.Background(), dir, config)
 }
 
-func (w *Watcher) triggersForFiles(files []string) []string {
-	// Get the list of triggers from all the files that changed
-	shouldTrigger := []string{}
-	for _, file := range files {
-		matching, err := w.MatchingTriggers(file)
-		if err != nil {
-			log.Println(err)
-		}
+// An eventMatch is a file change matched by a file trigger, along with the
+// batching settings that apply to it.
+type eventMatch struct {
+	settings Batching
+	change   fileChange
+}
+
+// matchEvent returns a match for every file trigger that responds to a file
+// event. Changes to the state directory are never matched.
+func (w *Watcher) matchEvent(ev fsnotify.Event) []eventMatch {
+	file := ev.Name
+	if !filepath.IsAbs(file) {
+		log.Printf("ignoring change to %s: path must be absolute\n", file)
+		return nil
+	}
+	if isStateFile(w.Directory, file) {
+		return nil
+	}
+
+	var (
+		cfg     = w.config()
+		matches []eventMatch
+	)
 
-		for _, match := range matching {
-			for _, trigger := range match.Triggers {
-				shouldTrigger = append(shouldTrigger, trigger)
+	for i, ft := range w.fileTriggers() {
+		var ops []FileOp
+		for _, op := range fileOpsFromEvent(ev.Op) {
+			if ft.Accepts(op) {
+				ops = append(ops, op)
 			}
 		}
+
+		if len(ops) == 0 || !ft.matches(file) {
+			continue
+		}
+
+		for _, op := range ops {
+			matches = append(matches, eventMatch{
+				settings: ft.Batching.or(cfg.Batching),
+				change: fileChange{
+					trigger: i,
+					steps:   ft.Triggers,
+					onBusy:  ft.OnBusy,
+					file:    file,
+					op:      op,
+				},
+			})
+		}
+	}
+
+	return matches
+}
+
+// changeSetFor builds the change set of steps to run in response to a list
+// of file changes.
+func (w *Watcher) changeSetFor(changes []fileChange) *changeSet {
+	set := newChangeSet(w.Directory)
+
+	for _, c := range changes {
+		for _, step := range c.steps {
+			set.add(step, c.trigger, c.file, c.op)
+		}
 	}
 
-	return uniqueStringSliceOrdered(shouldTrigger)
+	return set
 }
 
-func (w *Watcher) handleFilesChanged(ctx context.Context, files []string) {
-	triggerList := w.triggersForFiles(files)
+func (w *Watcher) handleFilesChanged(ctx context.Context, changes *changeSet) {
+	defer changes.Close()
+
+	// Every step and member of a parallel group shares the triggers ran in
+	// this sequence, so shared dependencies run once per batch.
+	ctx, _ = withDepRuns(ctx)
+
+	w.emit(Event{
+		Type:  EventFileBatchDetected,
+		Files: changes.all,
+		Steps: changes.triggers(),
+	})
 
 outer:
-	for _, trigger := range triggerList {
+	for _, step := range changes.steps {
 		select {
 		// Stop processing more triggers
 		case <-ctx.Done():
 			return
 		default:
-			fmt.Fprintf(w.Debug, "[%s] STARTING\n", trigger)
+			var err error
+			if step.Parallel != nil {
+				err = w.runParallel(ctx, step.Parallel, changes)
+			} else {
+				err = w.runStep(ctx, step.Trigger, changes)
+			}
 
-			err := w.Run(ctx, trigger)
 			if err != nil && err != context.Canceled {
-				fmt.Fprintf(w.Stderr, "[%s] FAILED: %v\n", trigger, err)
-
 				// Stop the other triggers from running if a command
 				// fails.
 				break outer
-			} else if err == context.Canceled {
-				fmt.Fprintf(w.Stderr, "[%s] CANCELLED\n", trigger)
 			}
 		}
 	}
 }
 
-func (w *Watcher) compileFiles() error {
-	w.files = make(map[string]*syntax.File)
+// runParallel runs a group of triggers at the same time and waits for all
+// of them to finish. As soon as one trigger fails, the others are cancelled
+// and its error is returned.
+func (w *Watcher) runParallel(ctx context.Context, triggers []string, changes *changeSet) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	p := syntax.NewParser()
-	for name, action := range w.Config.Actions {
-		r := strings.NewReader(action)
-		f, err := p.Parse(r, name)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+
+	for _, trigger := range triggers {
+		wg.Add(1)
+		go func(trigger string) {
+			defer wg.Done()
+
+			err := w.runStep(ctx, trigger, changes)
+			if err != nil && err != context.Canceled {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(trigger)
+	}
+
+	wg.Wait()
+
+	if firstErr == nil {
+		return ctx.Err()
+	}
+	return firstErr
+}
+
+// runStep runs a single step of a trigger sequence after its dependencies,
+// reporting its progress to the debug and error output. Triggers that
+// already ran as part of the same trigger sequence, for example as a
+// dependency of an earlier step or of another member of a parallel group,
+// are not ran again. The sequence is the one of the depRuns held by ctx, or
+// a new one if it has none.
+func (w *Watcher) runStep(ctx context.Context, trigger string, changes *changeSet) error {
+	ctx, runs := withDepRuns(ctx)
+
+	return runs.once(trigger, func() error {
+		name, action := w.parseTriggerName(trigger)
+		if action == "" {
+			if err := w.runDependencies(ctx, name, changes); err != nil {
+				w.reportStep(trigger, err)
+				return err
+			}
+		}
+
+		if desc := w.describe(trigger); desc != "" {
+			fmt.Fprintf(w.Debug, "[%s] STARTING: %s\n", trigger, desc)
+		} else {
+			fmt.Fprintf(w.Debug, "[%s] STARTING\n", trigger)
+		}
+		w.emit(Event{Type: EventTriggerStarted, Trigger: trigger})
+
+		err := w.run(ctx, trigger, changes)
+		w.reportStep(trigger, err)
+		return err
+	})
+}
+
+// reportStep reports the outcome of a step to the error output.
+func (w *Watcher) reportStep(trigger string, err error) {
+	switch {
+	case err == context.Canceled:
+		fmt.Fprintf(w.Stderr, "[%s] CANCELLED\n", trigger)
+		w.emit(Event{Type: EventTriggerCancelled, Trigger: trigger})
+	case err != nil:
+		fmt.Fprintf(w.Stderr, "[%s] FAILED: %v\n", trigger, err)
+		w.emit(Event{Type: EventTriggerFailed, Trigger: trigger, Error: err.Error()})
+	default:
+		w.emit(Event{Type: EventTriggerSucceeded, Trigger: trigger})
+	}
+}
+
+func (w *Watcher) compileActions(cfg Config) (map[string]*action, error) {
+	actions := make(map[string]*action)
+
+	for name, script := range cfg.Actions {
+		f, err := compileScript(name, script)
 		if err != nil {
-			return fmt.Errorf("failed parsing action %s: %v", name, err)
+			return nil, fmt.Errorf("failed parsing action %s: %v", name, err)
+		}
+
+		actions[name] = &action{
+			Dir:         w.scriptDir(script),
+			File:        f,
+			Timeout:     script.Timeout,
+			Description: script.Description,
 		}
-		w.files[name] = f
 	}
 
-	return nil
+	return actions, nil
 }
 
-func (w *Watcher) compileServices() error {
-	w.services = make(map[string]*service)
+func (w *Watcher) compileServices(cfg Config) (map[string]*service, error) {
+	services := make(map[string]*service)
+
+	for name, svc := range cfg.Services {
+		f, err := compileScript(name, svc.Script)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing service %s: %v", name, err)
+		}
+
+		ready, err := compileReadinessProbe(name, svc.Ready)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing readiness probe for service %s: %v", name, err)
+		}
 
-	p := syntax.NewParser()
-	for name, action := range w.Config.Services {
-		r := strings.NewReader(action)
-		f, err := p.Parse(r, name)
+		stopSignal, err := parseSignal(svc.StopSignal)
 		if err != nil {
-			return fmt.Errorf("failed parsing service %s: %v", name, err)
+			return nil, fmt.Errorf("invalid stop_signal for service %s: %v", name, err)
 		}
 
-		w.services[name] = &service{
-			Dir:  w.Directory,
-			File: f,
+		services[name] = &service{
+			Name:        name,
+			Events:      &w.events,
+			Dir:         w.scriptDir(svc.Script),
+			File:        f,
+			Description: svc.Script.Description,
+			Ready:       ready,
+			Restart:     svc.Restart,
+			MaxRestarts: svc.MaxRestarts,
+			Backoff:     svc.Backoff,
+			StopSignal:  stopSignal,
+			StopTimeout: svc.StopTimeout,
 		}
 	}
 
-	return nil
+	return services, nil
+}
+
+// config returns the current config of the watcher.
+func (w *Watcher) config() Config {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return w.Config
+}
+
+// compileFileTriggers returns a copy of the file triggers of cfg with their
+// patterns compiled, so they are compiled once per config load rather than
+// for every file event.
+func (w *Watcher) compileFileTriggers(cfg Config) []FileTrigger {
+	triggers := make([]FileTrigger, len(cfg.FileTriggers))
+	for i, ft := range cfg.FileTriggers {
+		var ignore func() *ignoreRules
+		if ft.usesIgnoreFiles(cfg.UseIgnoreFiles) {
+			ignore = w.ignoreRules
+		}
+
+		ft.compiled = ft.compile(w.Directory, ignore)
+		triggers[i] = ft
+	}
+	return triggers
+}
+
+// fileTriggers returns the compiled file triggers of the config. They are
+// compiled by Start and Reload; until the watcher is started, they are
+// compiled on every call.
+func (w *Watcher) fileTriggers() []FileTrigger {
+	cfg := w.config()
+	for _, ft := range cfg.FileTriggers {
+		if ft.compiled == nil || ft.compiled.root != w.Directory {
+			return w.compileFileTriggers(cfg)
+		}
+	}
+	return cfg.FileTriggers
+}
+
+// action returns the compiled action with the given name.
+func (w *Watcher) action(name string) (*action, bool) {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	a, ok := w.actions[name]
+	return a, ok
+}
+
+// service returns the compiled service with the given name.
+func (w *Watcher) service(name string) (*service, bool) {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	s, ok := w.services[name]
+	return s, ok
 }
 
 // reducePaths will reduce the number of watched paths by combining
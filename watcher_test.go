@@ -0,0 +1,308 @@
+package gowatch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tempDir creates a temporary directory with symlinks resolved, so it
+// matches the paths reported for files in it.
+func tempDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "gowatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// startWatcher starts w with a fakeBackend in the background. The returned
+// function stops w and waits for Start to return.
+func startWatcher(t *testing.T, w *Watcher) (*fakeBackend, func()) {
+	t.Helper()
+
+	b := newFakeBackend()
+	w.NewBackend = func() (Backend, error) { return b, nil }
+
+	done := make(chan error, 1)
+	go func() { done <- w.Start() }()
+
+	return b, func() {
+		w.Close()
+		<-done
+	}
+}
+
+// countLines returns the number of lines in file, which is missing until
+// the first line is written.
+func countLines(file string) int {
+	b, _ := ioutil.ReadFile(file)
+	return len(strings.Fields(string(b)))
+}
+
+// waitLines waits for file to have n lines and fails if it has more than n
+// once settle has passed after that.
+func waitLines(t *testing.T, file string, n int, settle time.Duration) {
+	t.Helper()
+
+	for start := time.Now(); countLines(file) < n; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("expected %d lines in %s, got %d", n, filepath.Base(file), countLines(file))
+		}
+	}
+
+	time.Sleep(settle)
+	if got := countLines(file); got != n {
+		t.Fatalf("expected %d lines in %s, got %d", n, filepath.Base(file), got)
+	}
+}
+
+func TestPauseDropsPendingChanges(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	log := filepath.Join(dir, "log")
+	w := NewWatcher(dir, Config{
+		Actions: map[string]Script{"count": {Run: "echo run >> " + log}},
+		FileTriggers: []FileTrigger{{
+			Include:  []string{"*.go"},
+			Triggers: []Step{{Trigger: "count"}},
+			Batching: Batching{Debounce: 200 * time.Millisecond},
+		}},
+	})
+
+	b, stop := startWatcher(t, w)
+	defer stop()
+
+	// The change seen before pausing is dropped, even though the watcher
+	// is resumed before its batch is due.
+	b.write(t, filepath.Join(dir, "main.go"))
+	w.Pause()
+	w.Resume()
+	time.Sleep(400 * time.Millisecond)
+	if n := countLines(log); n != 0 {
+		t.Fatalf("expected the pending change to be dropped, ran %d times", n)
+	}
+
+	// Changes after resuming run the triggers again.
+	b.write(t, filepath.Join(dir, "main.go"))
+	waitLines(t, log, 1, 400*time.Millisecond)
+}
+
+// countWord returns the number of times word appears in file.
+func countWord(file, word string) int {
+	b, _ := ioutil.ReadFile(file)
+
+	n := 0
+	for _, f := range strings.Fields(string(b)) {
+		if f == word {
+			n++
+		}
+	}
+	return n
+}
+
+// waitWord waits for word to appear n times in file.
+func waitWord(t *testing.T, file, word string, n int) {
+	t.Helper()
+
+	for start := time.Now(); countWord(file, word) < n; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("expected %q %d times in %s, got %d", word, n, filepath.Base(file), countWord(file, word))
+		}
+	}
+}
+
+func TestBusyPolicies(t *testing.T) {
+	tt := []struct {
+		policy BusyPolicy
+		expect map[string]int
+	}{
+		// The running sequence is cancelled before it ends.
+		{BusyRestart, map[string]int{"start": 2, "end": 1}},
+		// The changes seen while busy cause a single extra run.
+		{BusyQueue, map[string]int{"start": 2, "end": 2}},
+		{BusyIgnore, map[string]int{"start": 1, "end": 1}},
+		// The running sequence is signalled and the changes seen while busy
+		// are thrown away.
+		{BusySignal, map[string]int{"start": 1, "hup": 1, "end": 1}},
+	}
+
+	for _, tc := range tt {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+
+			log := filepath.Join(dir, "log")
+			script := `trap "echo hup >> ` + log + `" HUP; ` +
+				`echo start >> ` + log + `; sleep 0.5 & wait; echo end >> ` + log
+
+			w := NewWatcher(dir, Config{
+				Actions: map[string]Script{"slow": {Shell: "sh", Run: script}},
+				FileTriggers: []FileTrigger{{
+					Include:  []string{"*.go"},
+					Triggers: []Step{{Trigger: "slow"}},
+					Batching: Batching{Debounce: 20 * time.Millisecond},
+					OnBusy:   tc.policy,
+				}},
+			})
+
+			b, stop := startWatcher(t, w)
+			defer stop()
+
+			b.write(t, filepath.Join(dir, "main.go"))
+			waitWord(t, log, "start", 1)
+
+			// Two changes while busy, which must be handled as one batch.
+			b.write(t, filepath.Join(dir, "main.go"))
+			b.write(t, filepath.Join(dir, "util.go"))
+
+			waitWord(t, log, "end", tc.expect["end"])
+			time.Sleep(time.Second)
+
+			for _, word := range []string{"start", "hup", "end"} {
+				if got := countWord(log, word); got != tc.expect[word] {
+					t.Errorf("expected %q %d times, got %d", word, tc.expect[word], got)
+				}
+			}
+		})
+	}
+}
+
+func TestFileTriggersCompiledOnce(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	gitignore := filepath.Join(dir, ".gitignore")
+	if err := ioutil.WriteFile(gitignore, []byte("gen.go\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{
+		Actions:        map[string]Script{"noop": {Run: "true"}},
+		UseIgnoreFiles: true,
+		FileTriggers: []FileTrigger{{
+			Include:  []string{"*.go"},
+			Triggers: []Step{{Trigger: "noop"}},
+		}},
+	}
+	w := NewWatcher(dir, cfg)
+
+	_, stop := startWatcher(t, w)
+	defer stop()
+
+	// Wait for Start to compile the config.
+	for start := time.Now(); w.config().FileTriggers[0].compiled == nil; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("expected file triggers to be compiled by Start")
+		}
+	}
+	if cfg.FileTriggers[0].compiled != nil {
+		t.Error("expected the config passed to NewWatcher to be left untouched")
+	}
+
+	compiled := w.fileTriggers()[0].compiled
+	if again := w.fileTriggers()[0].compiled; again != compiled {
+		t.Error("expected file triggers to be compiled only once")
+	}
+
+	// Matches uses the ignore rules cached by the watcher, which are only
+	// read again once an ignore file changes.
+	ft := w.config().FileTriggers[0]
+	gen := filepath.Join(dir, "gen.go")
+	if ft.Matches(dir, gen) {
+		t.Errorf("expected %s to be ignored", gen)
+	}
+	if err := ioutil.WriteFile(gitignore, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ft.Matches(dir, gen) {
+		t.Errorf("expected cached ignore rules to be used")
+	}
+	w.resetIgnoreRules()
+	if !ft.Matches(dir, gen) {
+		t.Errorf("expected %s to match once the ignore rules are reloaded", gen)
+	}
+
+	if err := w.Reload(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if w.fileTriggers()[0].compiled == compiled {
+		t.Error("expected file triggers to be compiled again by Reload")
+	}
+}
+
+func TestParallelFailureCancelsSiblings(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	log := filepath.Join(dir, "log")
+	w := NewWatcher(dir, Config{
+		Actions: map[string]Script{
+			"fail":  {Run: "exit 1"},
+			"sleep": {Run: "sleep 30"},
+			"after": {Run: "echo after >> " + log},
+		},
+		FileTriggers: []FileTrigger{{
+			Include: []string{"*.go"},
+			Triggers: []Step{
+				{Parallel: []string{"fail", "sleep"}},
+				{Trigger: "after"},
+			},
+			Batching: Batching{Debounce: 20 * time.Millisecond},
+		}},
+	})
+
+	events := w.Subscribe()
+	defer w.Unsubscribe(events)
+
+	b, stop := startWatcher(t, w)
+	defer stop()
+
+	b.write(t, filepath.Join(dir, "main.go"))
+
+	outcomes := make(map[string]EventType)
+	timeout := time.After(5 * time.Second)
+	for len(outcomes) < 2 {
+		select {
+		case ev := <-events:
+			switch ev.Type {
+			case EventTriggerSucceeded, EventTriggerFailed, EventTriggerCancelled:
+				outcomes[ev.Trigger] = ev.Type
+			case EventTriggerStarted:
+				if ev.Trigger == "after" {
+					t.Fatal("expected the step after a failed group not to run")
+				}
+			}
+		case <-timeout:
+			t.Fatalf("expected fail and sleep to finish, got %v", outcomes)
+		}
+	}
+
+	if outcomes["fail"] != EventTriggerFailed {
+		t.Errorf("expected fail to fail, got %s", outcomes["fail"])
+	}
+	if outcomes["sleep"] != EventTriggerCancelled {
+		t.Errorf("expected sleep to be cancelled, got %s", outcomes["sleep"])
+	}
+
+	// Give the sequence time to go on if it wrongly does.
+	time.Sleep(200 * time.Millisecond)
+	for len(events) > 0 {
+		if ev := <-events; ev.Trigger == "after" {
+			t.Errorf("expected after not to run, got %s", ev.Type)
+		}
+	}
+	if n := countLines(log); n != 0 {
+		t.Errorf("expected after not to run, ran %d times", n)
+	}
+}
@@ -0,0 +1,40 @@
+package gowatch
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// isWatchLimit returns whether err is the error inotify reports once
+// fs.inotify.max_user_watches watches exist, even if it was wrapped.
+func isWatchLimit(err error) bool {
+	switch e := err.(type) {
+	case syscall.Errno:
+		return e == syscall.ENOSPC
+	case *os.SyscallError:
+		return isWatchLimit(e.Err)
+	case *os.PathError:
+		return isWatchLimit(e.Err)
+	case interface{ Unwrap() error }:
+		return isWatchLimit(e.Unwrap())
+	default:
+		return false
+	}
+}
+
+// watchLimitError explains that the inotify watch limit was reached while
+// needed directories had to be watched.
+func watchLimitError(needed int) error {
+	limit := "unknown"
+	if n, ok := inotifyWatchLimit(); ok {
+		limit = strconv.Itoa(n)
+	}
+
+	return fmt.Errorf(
+		"reached the inotify watch limit: %d directories need to be watched, but fs.inotify.max_user_watches is %s and shared with other programs; "+
+			"raise it with sysctl, exclude directories, or set poll_on_watch_limit",
+		needed, limit,
+	)
+}
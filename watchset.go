@@ -0,0 +1,264 @@
+package gowatch
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// A watchSet tracks the directories added to a Backend.
+type watchSet struct {
+	n     Backend
+	debug io.Writer
+
+	// pollOnLimit polls directories that can't be watched because the
+	// inotify watch limit was reached, if n supports it.
+	pollOnLimit  bool
+	limitReached bool
+
+	lock sync.Mutex
+	dirs map[string]bool
+}
+
+func newWatchSet(n Backend, debug io.Writer) *watchSet {
+	return &watchSet{n: n, debug: debug, dirs: make(map[string]bool)}
+}
+
+// add starts watching dirs that aren't watched yet.
+func (s *watchSet) add(dirs []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, d := range dirs {
+		if s.dirs[d] {
+			continue
+		}
+
+		err := s.n.Add(d)
+		if err != nil && isWatchLimit(err) {
+			err = s.addPolled(d, s.needed(dirs[i:]))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to watch %s: %v", d, err)
+		}
+
+		s.dirs[d] = true
+		fmt.Fprintf(s.debug, "watching path %s\n", d)
+	}
+
+	return nil
+}
+
+// list returns the watched directories.
+func (s *watchSet) list() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	dirs := make([]string, 0, len(s.dirs))
+	for d := range s.dirs {
+		dirs = append(dirs, d)
+	}
+	return dirs
+}
+
+// needed returns how many directories are watched once the remaining dirs
+// are added.
+func (s *watchSet) needed(remaining []string) int {
+	n := len(s.dirs)
+	for _, d := range remaining {
+		if !s.dirs[d] {
+			n++
+		}
+	}
+	return n
+}
+
+// addPolled polls dir after the inotify watch limit was reached, or returns
+// an error explaining the limit if polling isn't enabled.
+func (s *watchSet) addPolled(dir string, needed int) error {
+	p, ok := s.n.(pollFallback)
+	if !s.pollOnLimit || !ok {
+		return watchLimitError(needed)
+	}
+
+	if !s.limitReached {
+		s.limitReached = true
+		log.Printf("WARNING: %v; polling the directories that can't be watched\n", watchLimitError(needed))
+	}
+	return p.addPolled(dir)
+}
+
+// sync makes dirs the set of watched directories.
+func (s *watchSet) sync(dirs []string) {
+	keep := make(map[string]bool)
+	for _, d := range dirs {
+		keep[d] = true
+	}
+
+	s.lock.Lock()
+	for d := range s.dirs {
+		if keep[d] {
+			continue
+		}
+
+		delete(s.dirs, d)
+		if err := s.n.Remove(d); err != nil {
+			fmt.Fprintf(s.debug, "failed to remove path %s: %v\n", d, err)
+		} else {
+			fmt.Fprintf(s.debug, "no longer watching path %s\n", d)
+		}
+	}
+	s.lock.Unlock()
+
+	for _, d := range dirs {
+		if err := s.add([]string{d}); err != nil {
+			fmt.Fprintln(s.debug, err)
+		}
+	}
+}
+
+// forget stops watching dir and every watched directory below it after dir
+// was removed or renamed. The watches of a renamed directory would otherwise
+// keep reporting events under its old path, and forgetting a removed
+// directory lets add watch it again once it is recreated.
+func (s *watchSet) forget(dir string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.dirs[dir] {
+		return
+	}
+
+	for d := range s.dirs {
+		if !isParent(dir, d) {
+			continue
+		}
+
+		// The watch of a removed directory is gone already, so errors
+		// are expected.
+		delete(s.dirs, d)
+		s.n.Remove(d)
+		fmt.Fprintf(s.debug, "no longer watching removed path %s\n", d)
+	}
+}
+
+// couldMatch returns a function that reports whether a directory could
+// hold files matched by any file trigger, and so should be watched. The
+// state directory and .git directories never are: git changes files in
+// them all the time, and watching them would use up inotify watches,
+// unless a file trigger points into one by name, as .git/HEAD does.
+func (w *Watcher) couldMatch() func(dir string) bool {
+	var (
+		patterns []patternList
+		ignores  []*ignoreRules
+		bases    []string
+	)
+
+	for _, ft := range w.fileTriggers() {
+		if len(ft.Triggers) == 0 {
+			continue
+		}
+		patterns = append(patterns, ft.compiled.patterns)
+		ignores = append(ignores, ft.compiled.ignoreRules())
+		bases = append(bases, ft.compiled.patterns.bases()...)
+	}
+
+	// explicit returns whether a file trigger points into dir by name.
+	explicit := func(dir string) bool {
+		for _, base := range bases {
+			if isParent(dir, base) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(dir string) bool {
+		if isStateFile(w.Directory, dir) || (filepath.Base(dir) == ".git" && !explicit(dir)) {
+			return false
+		}
+		for i, l := range patterns {
+			if l.couldInclude(dir) && !ignores[i].ignored(dir) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// walkWatched walks the tree at root and calls fn for every directory that
+// should be watched and every file within them. Directories that no file
+// trigger could match are skipped with everything below them.
+func (w *Watcher) walkWatched(root string, fn func(path string, dir bool)) {
+	couldMatch := w.couldMatch()
+
+	filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !fi.IsDir() {
+			fn(p, false)
+			return nil
+		}
+		if !couldMatch(p) {
+			return filepath.SkipDir
+		}
+
+		fn(p, true)
+		return nil
+	})
+}
+
+// watchedDirs returns the directories that need to be watched to see changes
+// to every file the file triggers could match, including files that don't
+// exist yet.
+func (w *Watcher) watchedDirs() []string {
+	var roots []string
+	for _, ft := range w.fileTriggers() {
+		if len(ft.Triggers) == 0 {
+			continue
+		}
+
+		for _, base := range ft.compiled.patterns.bases() {
+			// Watch the closest existing parent of a missing base, so
+			// the base is seen once it is created. The parent of a base
+			// within the watched directory is watched as well, so the
+			// base is seen again if it is removed and recreated.
+			for !isDir(base) && filepath.Dir(base) != base {
+				base = filepath.Dir(base)
+			}
+			if rel, err := filepath.Rel(w.Directory, base); err == nil && isSubpath(rel) {
+				base = filepath.Dir(base)
+			}
+			roots = append(roots, base)
+		}
+	}
+
+	// Walk nested roots only once.
+	sort.Strings(roots)
+	var dirs []string
+	for i, root := range roots {
+		if i > 0 && isParent(roots[i-1], root) {
+			roots[i] = roots[i-1]
+			continue
+		}
+
+		w.walkWatched(root, func(p string, dir bool) {
+			if dir {
+				dirs = append(dirs, p)
+			}
+		})
+	}
+
+	return w.fs.expand(dirs)
+}
+
+// isParent returns whether file is dir or below it.
+func isParent(dir string, file string) bool {
+	rel, err := filepath.Rel(dir, file)
+	return err == nil && (rel == "." || isSubpath(rel))
+}
@@ -0,0 +1,198 @@
+package gowatch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWatchSetForget(t *testing.T) {
+	b := newFakeBackend()
+	ws := newWatchSet(b, ioutil.Discard)
+
+	if err := ws.add([]string{"/root", "/root/build", "/root/build/js", "/root/buildx"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Directories that aren't watched are ignored.
+	ws.forget("/root/build/js/main.js")
+
+	ws.forget("/root/build")
+	if expect := []string{"/root", "/root/buildx"}; !reflect.DeepEqual(b.watched(), expect) {
+		t.Errorf("expected watched dirs %v, got %v", expect, b.watched())
+	}
+
+	// A recreated directory is watched again.
+	if err := ws.add([]string{"/root/build"}); err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{"/root", "/root/build", "/root/buildx"}; !reflect.DeepEqual(b.watched(), expect) {
+		t.Errorf("expected watched dirs %v, got %v", expect, b.watched())
+	}
+}
+
+func TestWatchSetLimit(t *testing.T) {
+	dirs := []string{"/root", "/root/a", "/root/b", "/root/c"}
+
+	b := newFakeBackend()
+	b.limit = 2
+	err := newWatchSet(b, ioutil.Discard).add(dirs)
+	if err == nil || !strings.Contains(err.Error(), "4 directories need to be watched") {
+		t.Errorf("expected watch limit error, got %v", err)
+	}
+
+	b = newFakeBackend()
+	b.limit = 2
+	ws := newWatchSet(b, ioutil.Discard)
+	ws.pollOnLimit = true
+	if err := ws.add(dirs); err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{"/root/b", "/root/c"}; !reflect.DeepEqual(b.polled, expect) {
+		t.Errorf("expected polled dirs %v, got %v", expect, b.polled)
+	}
+}
+
+// wrappedError wraps an error the way fmt.Errorf with %w does.
+type wrappedError struct{ err error }
+
+func (e wrappedError) Error() string { return "watch failed: " + e.err.Error() }
+func (e wrappedError) Unwrap() error { return e.err }
+
+func TestIsWatchLimit(t *testing.T) {
+	tt := []struct {
+		err   error
+		limit bool
+	}{
+		{syscall.ENOSPC, true},
+		{os.NewSyscallError("inotify_add_watch", syscall.ENOSPC), true},
+		{&os.PathError{Op: "watch", Path: "/root", Err: syscall.ENOSPC}, true},
+		{wrappedError{syscall.ENOSPC}, true},
+		{syscall.ENOENT, false},
+		{os.NewSyscallError("inotify_add_watch", syscall.EACCES), false},
+		{fmt.Errorf("no space left on device"), false},
+		{nil, false},
+	}
+
+	for _, tc := range tt {
+		if got := isWatchLimit(tc.err); got != tc.limit {
+			t.Errorf("isWatchLimit(%#v): expected %v, got %v", tc.err, tc.limit, got)
+		}
+	}
+}
+
+func TestWatchSetLimitPolls(t *testing.T) {
+	root, err := ioutil.TempDir("", "gowatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	dirs := []string{root}
+	for _, name := range []string{"a", "b", "c"} {
+		dir := filepath.Join(root, name)
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, dir)
+	}
+
+	// With PollOnWatchLimit, the notify backend is routed so it can fall
+	// back to polling.
+	w := NewWatcher(root, Config{Backend: BackendNotify, PollOnWatchLimit: true})
+	n, err := w.newBackend(newFSInspector(ioutil.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Close()
+	if _, ok := n.(*routedBackend); !ok {
+		t.Fatalf("expected a routed backend with poll_on_watch_limit, got %T", n)
+	}
+
+	notify := newFakeBackend()
+	notify.limit = 2
+	poll := newPollBackend(time.Hour)
+	b := newRoutedBackend(notify, poll, func(string) bool { return false })
+	defer b.Close()
+
+	ws := newWatchSet(b, ioutil.Discard)
+	ws.pollOnLimit = true
+	if err := ws.add(dirs); err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := dirs[:2]; !reflect.DeepEqual(notify.watched(), expect) {
+		t.Errorf("expected notified dirs %v, got %v", expect, notify.watched())
+	}
+
+	var polled []string
+	poll.lock.Lock()
+	for d := range poll.dirs {
+		polled = append(polled, d)
+	}
+	poll.lock.Unlock()
+	sort.Strings(polled)
+	if expect := dirs[2:]; !reflect.DeepEqual(polled, expect) {
+		t.Errorf("expected polled dirs %v, got %v", expect, polled)
+	}
+
+	// Polled directories are removed from the poll backend.
+	if err := b.Remove(dirs[3]); err != nil {
+		t.Fatal(err)
+	}
+	poll.lock.Lock()
+	_, stillPolled := poll.dirs[dirs[3]]
+	poll.lock.Unlock()
+	if stillPolled {
+		t.Errorf("expected %s to no longer be polled", dirs[3])
+	}
+}
+
+func TestWatchedDirsSkipGit(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	for _, d := range []string{".git/objects/ab", ".git/refs/heads", "cmd/app", "vendor/.git/hooks"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tt := []struct {
+		include []string
+		expect  []string
+	}{
+		{[]string{"**/*.go"}, []string{".", "cmd", "cmd/app", "vendor"}},
+		// Patterns pointing into .git by name still watch it.
+		{[]string{".git/HEAD"}, []string{".", ".git"}},
+		{[]string{".git/refs/**"}, []string{".git", ".git/refs", ".git/refs/heads"}},
+	}
+
+	for _, tc := range tt {
+		w := NewWatcher(dir, Config{
+			Actions: map[string]Script{"noop": {Run: "true"}},
+			FileTriggers: []FileTrigger{{
+				Include:  tc.include,
+				Triggers: []Step{{Trigger: "noop"}},
+			}},
+		})
+
+		var dirs []string
+		for _, d := range w.watchedDirs() {
+			rel, _ := filepath.Rel(dir, d)
+			dirs = append(dirs, filepath.ToSlash(rel))
+		}
+		sort.Strings(dirs)
+
+		if !reflect.DeepEqual(dirs, tc.expect) {
+			t.Errorf("%v: expected watched dirs %v, got %v", tc.include, tc.expect, dirs)
+		}
+	}
+}